@@ -2,11 +2,16 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	c "github.com/randomcoww/etcd-wrapper/pkg/config"
 	"github.com/randomcoww/etcd-wrapper/pkg/etcdexec"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdsupervisor"
+	"github.com/randomcoww/etcd-wrapper/pkg/health"
 	"github.com/randomcoww/etcd-wrapper/pkg/runner"
 	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
+	"github.com/randomcoww/etcd-wrapper/pkg/schedule"
 	"go.uber.org/zap"
 	"os"
 	"os/signal"
@@ -14,9 +19,22 @@ import (
 	"time"
 )
 
+type exitCodeError int
+
+func (e exitCodeError) Error() string {
+	return fmt.Sprintf("exit code %d", int(e))
+}
+
 func main() {
 	if len(os.Args) > 1 {
-		if err := run(os.Args[1:]); err != nil {
+		err := run(os.Args[1:])
+		var exitErr exitCodeError
+		switch {
+		case err == nil:
+			os.Exit(0)
+		case errors.As(err, &exitErr):
+			os.Exit(int(exitErr))
+		default:
 			os.Exit(1)
 		}
 	}
@@ -36,6 +54,10 @@ func run(args []string) error {
 		logger.Error("not enough args provided")
 		return fmt.Errorf("not enough arguments")
 	}
+	if cmd == "backup" {
+		logger.Warn("backup command is deprecated, use sidecar")
+		cmd = "sidecar"
+	}
 
 	config, err := c.NewConfig(cmd, args)
 	if err != nil {
@@ -53,16 +75,64 @@ func run(args []string) error {
 		return err
 	}
 
+	if config.HealthListenAddress != "" {
+		config.Health = health.NewState()
+		if err := runner.ServeHealth(ctx, config, s3); err != nil {
+			logger.Error("serve health", zap.Error(err))
+			return err
+		}
+	}
+
 	switch cmd {
 	case "run":
 		logger.Info("Start etcd run with", zap.Object("config", config))
 
-		if err := runner.RunEtcd(ctx, config, &etcdexec.EtcdExec{}, s3); err != nil {
+		if !config.Supervise {
+			if err := runner.RunEtcd(ctx, config, &etcdexec.EtcdExec{}, s3); err != nil {
+				logger.Error("start etcd", zap.Error(err))
+				return err
+			}
+			break
+		}
+
+		p := &etcdsupervisor.EtcdSupervisor{
+			ShutdownHook: func() {
+				// signal context is already cancelled at this point
+				departCtx, departCancel := context.WithTimeout(context.Background(), config.ShutdownTimeout)
+				defer departCancel()
+				if err := runner.DepartCluster(departCtx, config); err != nil {
+					logger.Error("depart cluster", zap.Error(err))
+				}
+			},
+		}
+		if err := runner.RunEtcd(ctx, config, p, s3); err != nil {
 			logger.Error("start etcd", zap.Error(err))
+			p.Stop()
+			p.Wait()
 			return err
 		}
+		err := p.Wait()
+		logger.Info("etcd exited", zap.Int("code", p.ExitCode()), zap.Error(err))
+		logger.Sync()
+		if p.ExitCode() != 0 {
+			return exitCodeError(p.ExitCode())
+		}
+		return nil
+
+	case "plan":
+		plan, err := runner.PlanEtcd(ctx, config, s3)
+		if err != nil {
+			logger.Error("plan etcd", zap.Error(err))
+			return err
+		}
+		if config.PlanOutput == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			return enc.Encode(plan)
+		}
+		return plan.WriteText(os.Stdout)
 
-	case "backup":
+	case "sidecar":
 		logger.Info("start etcd backup with", zap.Object("config", config))
 
 		verifyS3Ctx, verifyS3Cancel := context.WithTimeout(ctx, config.S3VerifyTimeout)
@@ -74,15 +144,48 @@ func run(args []string) error {
 			return err
 		}
 
-		for {
-			timer := time.NewTimer(config.BackupInterval)
-			select {
-			case <-ctx.Done():
-				return nil
-			case <-timer.C:
-				runner.RunBackup(ctx, config, s3)
-			}
+		if config.DefragInterval > 0 {
+			go func() {
+				for {
+					timer := time.NewTimer(config.DefragInterval)
+					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return
+					case <-timer.C:
+						runner.RunDefragment(ctx, config)
+					}
+				}
+			}()
+		}
+
+		if config.StaleMemberTimeout > 0 {
+			go func() {
+				memberCleanup := &runner.MemberCleanup{}
+				for {
+					timer := time.NewTimer(config.StaleMemberInterval)
+					select {
+					case <-ctx.Done():
+						timer.Stop()
+						return
+					case <-timer.C:
+						memberCleanup.Run(ctx, config)
+					}
+				}
+			}()
+		}
+
+		scheduler := &schedule.Scheduler{
+			Name:      "backup",
+			Schedule:  config.BackupSchedule,
+			Jitter:    config.BackupJitter,
+			MissedRun: config.BackupMissedRun,
+			Logger:    logger,
 		}
+		scheduler.Run(ctx, runner.LastBackupTime(ctx, config, s3), func(ctx context.Context) {
+			runner.RunBackup(ctx, config, s3)
+		})
+		return nil
 	}
 	return fmt.Errorf("unsupported command %s", cmd)
 }
@@ -0,0 +1,297 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
+	"go.uber.org/zap"
+	"os"
+	"slices"
+	"time"
+)
+
+const (
+	internalKeyPrefix        string        = s3client.InternalKeyPrefix
+	restoreManifestKeySuffix string        = "restore-manifest.json"
+	barrierWaitBetween       time.Duration = 2 * time.Second
+)
+
+// RestoreManifest records the snapshot all members agree to restore
+// Empty Key means no backup was found and members start a new cluster
+// The member that writes the manifest verifies the snapshot before other members download it
+// Revision is from backup metadata until verified and from the snapshot after
+type RestoreManifest struct {
+	Key       string    `json:"key"`
+	Revision  int64     `json:"revision"`
+	Size      int64     `json:"size,omitempty"`
+	Hash      uint32    `json:"hash,omitempty"`
+	Verified  bool      `json:"verified"`
+	Rejected  []string  `json:"rejected,omitempty"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// InternalKey returns key for wrapper state objects
+// These are kept under InternalKeyPrefix which S3 client does not list as backups
+func InternalKey(config *c.Config, suffix string) string {
+	return internalKeyPrefix + config.S3BackupKeyPrefix + suffix
+}
+
+func restoreManifestKey(config *c.Config) string {
+	return InternalKey(config, restoreManifestKeySuffix)
+}
+
+// RestoreSnapshotWithBarrier restores the snapshot recorded in a shared manifest object
+// The first member to write the manifest picks the snapshot from backup metadata and verifies it. Other members restore the same key and revision
+func RestoreSnapshotWithBarrier(ctx context.Context, config *c.Config, s3 s3client.Client, versionBump uint64) (string, error) {
+	barrierCtx, barrierCancel := context.WithTimeout(ctx, config.RestoreBarrierTimeout)
+	defer barrierCancel()
+
+	dir, err := os.MkdirTemp("", "etcd-wrapper-*")
+	if err != nil {
+		config.Logger.Error("create path for snapshot failed", zap.Error(err))
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	for {
+		manifest, snapshotFile, err := agreeRestoreManifest(barrierCtx, config, s3, dir)
+		if err == nil && manifest != nil {
+			key, err := restoreFromManifest(ctx, config, s3, manifest, snapshotFile, dir, versionBump)
+			if manifest.Key != "" {
+				recordRestore(config, manifest.Key, err)
+			}
+			return key, err
+		}
+		if err != nil {
+			config.Logger.Error("restore barrier attempt failed", zap.Error(err))
+		}
+
+		timer := time.NewTimer(barrierWaitBetween)
+		select {
+		case <-barrierCtx.Done():
+			return "", fmt.Errorf("restore barrier: members did not agree on a snapshot within %s: %w", config.RestoreBarrierTimeout, barrierCtx.Err())
+		case <-timer.C:
+			continue
+		}
+	}
+}
+
+// agreeRestoreManifest returns the verified manifest or writes and verifies a new one
+// Snapshot file is returned if this member downloaded the snapshot to verify it
+// Returns nil manifest if the manifest is not verified yet or another member won the write and the manifest should be read again
+func agreeRestoreManifest(ctx context.Context, config *c.Config, s3 s3client.Client, dir string) (*RestoreManifest, string, error) {
+	manifest, etag, err := readRestoreManifest(ctx, config, s3)
+	if err != nil {
+		return nil, "", err
+	}
+	if manifest != nil && time.Now().Before(manifest.ExpiresAt) {
+		if !manifest.Verified && manifest.Key != "" {
+			config.Logger.Info("waiting for restore manifest to be verified", zap.String("key", manifest.Key), zap.String("createdBy", manifest.CreatedBy))
+			return nil, "", nil
+		}
+		config.Logger.Info("found restore manifest", zap.String("key", manifest.Key), zap.Int64("revision", manifest.Revision), zap.String("createdBy", manifest.CreatedBy))
+		return manifest, "", nil
+	}
+
+	var rejected []string
+	if manifest != nil {
+		rejected = manifest.Rejected
+	}
+	proposed, err := proposeRestoreManifest(ctx, config, s3, rejected)
+	if err != nil {
+		return nil, "", err
+	}
+	b, err := json.Marshal(proposed)
+	if err != nil {
+		return nil, "", err
+	}
+	// replace only the manifest read above so that concurrent writers cannot both win
+	ok, err := s3.WriteIfMatch(ctx, config, restoreManifestKey(config), b, etag)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		config.Logger.Info("restore manifest written by another member")
+		return nil, "", nil
+	}
+	config.Logger.Info("wrote restore manifest", zap.String("key", proposed.Key), zap.Int64("revision", proposed.Revision))
+	if proposed.Key == "" {
+		return proposed, "", nil
+	}
+	return verifyRestoreManifest(ctx, config, s3, proposed, dir)
+}
+
+// verifyRestoreManifest downloads and verifies the snapshot in a manifest written by this member and marks it verified
+// A snapshot that is not usable is added to rejected and the manifest is expired so that the next attempt proposes another
+func verifyRestoreManifest(ctx context.Context, config *c.Config, s3 s3client.Client, manifest *RestoreManifest, dir string) (*RestoreManifest, string, error) {
+	written := *manifest
+	snapshotFile, status, err := readSnapshotStatus(ctx, config, s3, s3client.ObjectInfo{Key: manifest.Key, Size: manifest.Size}, dir)
+	if err == nil && config.RestoreRevision > 0 && status.Revision > config.RestoreRevision {
+		err = fmt.Errorf("%w: %d > %d", errRevisionAbove, status.Revision, config.RestoreRevision)
+	}
+	if err != nil {
+		if snapshotFile != "" {
+			os.Remove(snapshotFile)
+		}
+		recordRestore(config, manifest.Key, err)
+		config.Logger.Error("snapshot not usable for restore", zap.String("key", manifest.Key), zap.Error(err))
+		manifest.Rejected = append(manifest.Rejected, manifest.Key)
+		manifest.ExpiresAt = time.Now()
+		if _, err := updateRestoreManifest(ctx, config, s3, &written, manifest); err != nil {
+			return nil, "", err
+		}
+		return nil, "", nil
+	}
+
+	manifest.Revision = status.Revision
+	manifest.Hash = status.Hash
+	manifest.Verified = true
+	ok, err := updateRestoreManifest(ctx, config, s3, &written, manifest)
+	if err != nil || !ok {
+		os.Remove(snapshotFile)
+		return nil, "", err
+	}
+	config.Logger.Info("verified restore manifest", zap.String("key", manifest.Key), zap.Int64("revision", manifest.Revision))
+	return manifest, snapshotFile, nil
+}
+
+// updateRestoreManifest replaces manifest only if it is still the one written
+func updateRestoreManifest(ctx context.Context, config *c.Config, s3 s3client.Client, written, manifest *RestoreManifest) (bool, error) {
+	current, etag, err := readRestoreManifest(ctx, config, s3)
+	if err != nil {
+		return false, err
+	}
+	if current == nil || current.Key != written.Key || current.CreatedBy != written.CreatedBy || !current.CreatedAt.Equal(written.CreatedAt) {
+		config.Logger.Info("restore manifest replaced by another member")
+		return false, nil
+	}
+	b, err := json.Marshal(manifest)
+	if err != nil {
+		return false, err
+	}
+	return s3.WriteIfMatch(ctx, config, restoreManifestKey(config), b, etag)
+}
+
+// CurrentRestoreManifest returns the manifest members are restoring from or nil if there is none
+func CurrentRestoreManifest(ctx context.Context, config *c.Config, s3 s3client.Client) (*RestoreManifest, error) {
+	manifest, _, err := readRestoreManifest(ctx, config, s3)
+	if err != nil || manifest == nil || !time.Now().Before(manifest.ExpiresAt) {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func readRestoreManifest(ctx context.Context, config *c.Config, s3 s3client.Client) (*RestoreManifest, string, error) {
+	b, etag, err := s3.Read(ctx, config, restoreManifestKey(config))
+	if err != nil {
+		return nil, "", fmt.Errorf("read restore manifest: %w", err)
+	}
+	if etag == "" {
+		return nil, "", nil
+	}
+	manifest := &RestoreManifest{}
+	if err := json.Unmarshal(b, manifest); err != nil {
+		// treat as expired so that it gets replaced
+		config.Logger.Error("parse restore manifest failed", zap.Error(err))
+		return nil, etag, nil
+	}
+	return manifest, etag, nil
+}
+
+// proposeRestoreManifest picks the newest snapshot from listed backups and their metadata without downloading
+// Metadata revision is taken before the snapshot so backups with metadata revision above restore revision are skipped here and the rest once verified
+func proposeRestoreManifest(ctx context.Context, config *c.Config, s3 s3client.Client, rejected []string) (*RestoreManifest, error) {
+	now := time.Now()
+	manifest := &RestoreManifest{
+		Rejected:  rejected,
+		CreatedBy: config.Env["ETCD_NAME"],
+		CreatedAt: now,
+		ExpiresAt: now.Add(config.InitialClusterTimeout + config.RestoreBarrierTimeout),
+	}
+
+	objects, err := RestoreCandidates(ctx, config, s3)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		key := object.Key
+		metadata, err := ReadMetadata(ctx, config, s3, object)
+		if err == nil && slices.Contains(rejected, key) {
+			err = fmt.Errorf("%w: %s: rejected by restore barrier", errSnapshotRejected, key)
+		}
+		if err != nil {
+			config.Logger.Error("snapshot not usable for restore", zap.String("key", key), zap.Error(err))
+			if selectedSnapshot(config) && !config.RestoreFallback {
+				return nil, fmt.Errorf("selected snapshot %s not usable and fallback is not enabled: %w", key, err)
+			}
+			continue
+		}
+		if metadata != nil {
+			if config.RestoreRevision > 0 && metadata.Revision > config.RestoreRevision {
+				config.Logger.Info("skipping snapshot", zap.String("key", key), zap.Int64("revision", metadata.Revision))
+				continue
+			}
+			manifest.Revision = metadata.Revision
+		}
+		manifest.Key = key
+		manifest.Size = object.Size
+		return manifest, nil
+	}
+	if len(objects) > 0 {
+		return nil, fmt.Errorf("no usable snapshot found in %d backups", len(objects))
+	}
+	return manifest, nil
+}
+
+// readSnapshotStatus downloads and verifies snapshot and returns the downloaded file
+func readSnapshotStatus(ctx context.Context, config *c.Config, s3 s3client.Client, object s3client.ObjectInfo, dir string) (string, *SnapshotStatus, error) {
+	restoreCtx, restoreCancel := context.WithTimeout(ctx, time.Duration(config.RestoreTimeout))
+	defer restoreCancel()
+
+	snapshotFile, stored, ok, err := downloadSnapshot(restoreCtx, config, s3, object, dir)
+	if err != nil {
+		return snapshotFile, nil, err
+	}
+	if !ok {
+		return snapshotFile, nil, fmt.Errorf("snapshot %s not found", object.Key)
+	}
+	status, err := verifySnapshot(restoreCtx, config, snapshotFile, stored, object)
+	return snapshotFile, status, err
+}
+
+// restoreFromManifest restores the agreed snapshot. Snapshot is downloaded unless this member already did so to verify it
+func restoreFromManifest(ctx context.Context, config *c.Config, s3 s3client.Client, manifest *RestoreManifest, snapshotFile, dir string, versionBump uint64) (string, error) {
+	if manifest.Key == "" {
+		config.Logger.Info("restore manifest has no snapshot")
+		return "", nil
+	}
+	restoreCtx, restoreCancel := context.WithTimeout(ctx, time.Duration(config.RestoreTimeout))
+	defer restoreCancel()
+
+	if snapshotFile == "" {
+		var (
+			status *SnapshotStatus
+			err    error
+		)
+		snapshotFile, status, err = readSnapshotStatus(ctx, config, s3, s3client.ObjectInfo{Key: manifest.Key, Size: manifest.Size}, dir)
+		if err != nil {
+			return "", fmt.Errorf("restore barrier: agreed snapshot %s could not be read: %w", manifest.Key, err)
+		}
+		if status.Revision != manifest.Revision {
+			return "", fmt.Errorf("restore barrier: agreed snapshot %s has revision %d, expected %d", manifest.Key, status.Revision, manifest.Revision)
+		}
+		if manifest.Hash != 0 && status.Hash != manifest.Hash {
+			return "", fmt.Errorf("restore barrier: agreed snapshot %s has hash %d, expected %d", manifest.Key, status.Hash, manifest.Hash)
+		}
+	}
+	if err := restoreV3Snapshot(restoreCtx, config, snapshotFile, versionBump); err != nil {
+		config.Logger.Error("restore snapshot failed", zap.Error(err))
+		return "", err
+	}
+	config.Logger.Info("restored snapshot success", zap.String("key", manifest.Key), zap.Int64("revision", manifest.Revision))
+	return manifest.Key, nil
+}
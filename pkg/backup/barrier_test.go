@@ -0,0 +1,156 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestRestoreSnapshotWithBarrier(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	minioClient := &mockS3{}
+
+	// --- first member picks snapshot --- //
+
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	config, err := mockConfig("barrier", dataPath)
+	assert.NoError(t, err)
+	config.RestoreBarrierTimeout = 4 * time.Second
+
+	key, err := RestoreSnapshotWithBarrier(ctx, config, minioClient, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "dummy", key)
+
+	manifest := &RestoreManifest{}
+	err = json.Unmarshal(minioClient.manifest, manifest)
+	assert.NoError(t, err)
+	assert.Equal(t, "dummy", manifest.Key)
+	assert.Equal(t, "node0", manifest.CreatedBy)
+	assert.True(t, manifest.Verified)
+	assert.Equal(t, int64(3), manifest.Revision)
+	assert.NotZero(t, manifest.Hash)
+	etag := minioClient.etag
+
+	// snapshot downloaded once to verify and reused for restore
+	assert.Equal(t, 1, minioClient.downloads)
+
+	// --- second member restores same snapshot --- //
+
+	dataPath2, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath2)
+
+	config2, err := mockConfig("barrier", dataPath2)
+	assert.NoError(t, err)
+	config2.RestoreBarrierTimeout = 4 * time.Second
+
+	key, err = RestoreSnapshotWithBarrier(ctx, config2, minioClient, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "dummy", key)
+	assert.Equal(t, etag, minioClient.etag)
+	assert.Equal(t, 2, minioClient.downloads)
+}
+
+func TestRestoreSnapshotWithBarrierRejected(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	config, err := mockConfig("barrier", dataPath)
+	assert.NoError(t, err)
+	config.RestoreBarrierTimeout = 4 * time.Second
+
+	// --- newest snapshot fails verification and next newest is agreed --- //
+
+	minioClient := &mockS3{
+		objects: []s3client.ObjectInfo{
+			{Key: "a-good", Size: mockSnapshotSize(), LastModified: time.Now()},
+			{Key: "b-bad", Size: 7, LastModified: time.Now()},
+		},
+		content: map[string][]byte{
+			"b-bad": []byte("garbage"),
+		},
+	}
+
+	key, err := RestoreSnapshotWithBarrier(ctx, config, minioClient, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "a-good", key)
+
+	manifest := &RestoreManifest{}
+	err = json.Unmarshal(minioClient.manifest, manifest)
+	assert.NoError(t, err)
+	assert.Equal(t, "a-good", manifest.Key)
+	assert.True(t, manifest.Verified)
+	assert.Equal(t, []string{"b-bad"}, manifest.Rejected)
+}
+
+func TestRestoreSnapshotWithBarrierUnverified(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	config, err := mockConfig("barrier", dataPath)
+	assert.NoError(t, err)
+	config.RestoreBarrierTimeout = 2 * time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	// --- member waits for writer of manifest to verify snapshot --- //
+
+	b, err := json.Marshal(&RestoreManifest{
+		Key:       "dummy",
+		Revision:  3,
+		CreatedBy: "node1",
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Minute),
+	})
+	assert.NoError(t, err)
+	minioClient := &mockS3{
+		manifest: b,
+		etag:     "1",
+	}
+
+	_, err = RestoreSnapshotWithBarrier(ctx, config, minioClient, 0)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, 0, minioClient.downloads)
+	assert.Equal(t, "1", minioClient.etag)
+}
+
+func TestRestoreSnapshotWithBarrierNoBackup(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	config, err := mockConfig("barrier", dataPath)
+	assert.NoError(t, err)
+	config.RestoreBarrierTimeout = 4 * time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	// --- manifest agreed on new cluster --- //
+
+	b, err := json.Marshal(&RestoreManifest{
+		CreatedBy: "node1",
+		CreatedAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Minute),
+	})
+	assert.NoError(t, err)
+	minioClient := &mockS3{
+		manifest: b,
+		etag:     "1",
+	}
+
+	key, err := RestoreSnapshotWithBarrier(ctx, config, minioClient, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "", key)
+	assert.Equal(t, "1", minioClient.etag)
+}
@@ -0,0 +1,104 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"io"
+)
+
+const (
+	CodecNone string = "none"
+	CodecGzip string = "gzip"
+	CodecZstd string = "zstd"
+
+	codecMetadataKey string = "Codec"
+)
+
+var (
+	codecSuffix = map[string]string{
+		CodecNone: "",
+		CodecGzip: ".gz",
+		CodecZstd: ".zst",
+	}
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressReader returns reader of snapshot compressed with the configured codec
+// Returned reader must be closed so that the compressing goroutine exits if upload stops early
+func compressReader(config *c.Config, reader io.Reader) io.ReadCloser {
+	if config.BackupCompression == "" || config.BackupCompression == CodecNone {
+		return io.NopCloser(reader)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := compressWriter(config, pw)
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(w, reader); err != nil {
+			w.Close()
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+	return pr
+}
+
+// compressWriter returns writer for codec. Level of 0 uses the codec default
+func compressWriter(config *c.Config, w io.Writer) (io.WriteCloser, error) {
+	switch config.BackupCompression {
+	case CodecGzip:
+		level := config.BackupCompressionLevel
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		return gzip.NewWriterLevel(w, level)
+	case CodecZstd:
+		level := zstd.SpeedDefault
+		if config.BackupCompressionLevel != 0 {
+			level = zstd.EncoderLevelFromZstd(config.BackupCompressionLevel)
+		}
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(level))
+	}
+	return nil, fmt.Errorf("unsupported compression %s", config.BackupCompression)
+}
+
+// decompressReader detects codec from magic bytes so that uncompressed backups and backups with any key remain restorable
+func decompressReader(reader io.Reader) (io.ReadCloser, string, error) {
+	buffered := bufio.NewReader(reader)
+	magic, _ := buffered.Peek(len(zstdMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		r, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, CodecGzip, err
+		}
+		return r, CodecGzip, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		r, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, CodecZstd, err
+		}
+		return r.IOReadCloser(), CodecZstd, nil
+	}
+	return io.NopCloser(buffered), CodecNone, nil
+}
+
+// countingReader counts bytes read through it
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
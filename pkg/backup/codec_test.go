@@ -0,0 +1,50 @@
+package backup
+
+import (
+	"bytes"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func TestCodec(t *testing.T) {
+	data := bytes.Repeat([]byte("test-snapshot-data-"), 1000)
+
+	tests := []struct {
+		name  string
+		codec string
+		level int
+	}{
+		{name: "none", codec: CodecNone},
+		{name: "gzip", codec: CodecGzip},
+		{name: "gzip level", codec: CodecGzip, level: 9},
+		{name: "zstd", codec: CodecZstd},
+		{name: "zstd level", codec: CodecZstd, level: 19},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &c.Config{
+				BackupCompression:      tt.codec,
+				BackupCompressionLevel: tt.level,
+			}
+			reader := compressReader(config, bytes.NewReader(data))
+			defer reader.Close()
+			compressed, err := io.ReadAll(reader)
+			assert.NoError(t, err)
+			if tt.codec != CodecNone {
+				assert.Less(t, len(compressed), len(data))
+			}
+
+			body, codec, err := decompressReader(bytes.NewReader(compressed))
+			assert.NoError(t, err)
+			defer body.Close()
+			assert.Equal(t, tt.codec, codec)
+
+			decompressed, err := io.ReadAll(body)
+			assert.NoError(t, err)
+			assert.Equal(t, data, decompressed)
+		})
+	}
+}
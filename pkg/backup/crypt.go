@@ -0,0 +1,234 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"io"
+)
+
+// Encrypted snapshots are written as a header followed by AES-256-GCM sealed chunks
+//
+//	header: magic | key ID length (1) | key ID | salt (32)
+//	chunk:  final flag (1) | sealed length (4) | sealed chunk
+//
+// Each object is sealed with a key derived from the master key and a random salt so that chunk counters can be used as nonces.
+// Header is authenticated with every chunk and the final flag is part of the nonce so that truncation and reordering are detected.
+
+const (
+	encryptionMetadataKey string = "Encryption-Key-Id"
+
+	encryptChunkSize int = 64 * 1024
+	encryptSaltSize  int = 32
+)
+
+var (
+	encryptMagic = []byte("EWENC\x01")
+
+	errDecrypt = errors.New("decrypt snapshot failed")
+)
+
+// encryptReader returns reader of snapshot encrypted with the active key
+// Returned reader must be closed so that the encrypting goroutine exits if upload stops early
+func encryptReader(config *c.Config, reader io.Reader) io.ReadCloser {
+	if config.BackupEncryptionKeyID == "" {
+		return io.NopCloser(reader)
+	}
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := encryptWriter(pw, config.BackupEncryptionKeyID, config.BackupEncryptionKeys[config.BackupEncryptionKeyID])
+		if err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if _, err := io.Copy(w, reader); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		pw.CloseWithError(w.Close())
+	}()
+	return pr
+}
+
+type chunkWriter struct {
+	w       io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	buf     []byte
+	counter uint64
+}
+
+func encryptWriter(w io.Writer, id string, key []byte) (io.WriteCloser, error) {
+	salt := make([]byte, encryptSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	header := append(append(append(bytes.Clone(encryptMagic), byte(len(id))), id...), salt...)
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	return &chunkWriter{
+		w:      w,
+		aead:   aead,
+		header: header,
+		buf:    make([]byte, 0, encryptChunkSize),
+	}, nil
+}
+
+func (e *chunkWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		// keep a full chunk buffered until more data arrives since only the last chunk may be final
+		if len(e.buf) == encryptChunkSize {
+			if err := e.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buf[len(e.buf):encryptChunkSize], p)
+		e.buf = e.buf[:len(e.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (e *chunkWriter) Close() error {
+	return e.seal(true)
+}
+
+func (e *chunkWriter) seal(final bool) error {
+	sealed := e.aead.Seal(nil, chunkNonce(e.aead, e.counter, final), e.buf, e.header)
+	record := make([]byte, 5, 5+len(sealed))
+	if final {
+		record[0] = 1
+	}
+	binary.BigEndian.PutUint32(record[1:], uint32(len(sealed)))
+	if _, err := e.w.Write(append(record, sealed...)); err != nil {
+		return err
+	}
+	e.counter++
+	e.buf = e.buf[:0]
+	return nil
+}
+
+// decryptReader decrypts snapshots written by encryptWriter with any configured key
+// Unencrypted snapshots are passed through so that backups taken before encryption was enabled remain restorable
+func decryptReader(config *c.Config, reader io.Reader) (io.Reader, string, error) {
+	buffered := bufio.NewReader(reader)
+	magic, _ := buffered.Peek(len(encryptMagic))
+	if !bytes.Equal(magic, encryptMagic) {
+		return buffered, "", nil
+	}
+
+	header := make([]byte, len(encryptMagic)+1)
+	if _, err := io.ReadFull(buffered, header); err != nil {
+		return nil, "", fmt.Errorf("%w: %w", errDecrypt, err)
+	}
+	rest := make([]byte, int(header[len(header)-1])+encryptSaltSize)
+	if _, err := io.ReadFull(buffered, rest); err != nil {
+		return nil, "", fmt.Errorf("%w: %w", errDecrypt, err)
+	}
+	header = append(header, rest...)
+	id, salt := string(rest[:len(rest)-encryptSaltSize]), rest[len(rest)-encryptSaltSize:]
+
+	key, ok := config.BackupEncryptionKeys[id]
+	if !ok {
+		return nil, id, fmt.Errorf("%w: key %s not configured", errDecrypt, id)
+	}
+	aead, err := newAEAD(key, salt)
+	if err != nil {
+		return nil, id, err
+	}
+	return &chunkReader{
+		r:      buffered,
+		aead:   aead,
+		header: header,
+	}, id, nil
+}
+
+type chunkReader struct {
+	r       io.Reader
+	aead    cipher.AEAD
+	header  []byte
+	buf     []byte
+	counter uint64
+	final   bool
+}
+
+func (d *chunkReader) Read(p []byte) (int, error) {
+	for len(d.buf) == 0 {
+		if d.final {
+			// nothing may follow the final chunk
+			if n, _ := d.r.Read(make([]byte, 1)); n > 0 {
+				return 0, fmt.Errorf("%w: data after final chunk", errDecrypt)
+			}
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.buf)
+	d.buf = d.buf[n:]
+	return n, nil
+}
+
+func (d *chunkReader) open() error {
+	record := make([]byte, 5)
+	if _, err := io.ReadFull(d.r, record); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return fmt.Errorf("%w: truncated: %w", errDecrypt, err)
+	}
+	size := int(binary.BigEndian.Uint32(record[1:]))
+	if record[0] > 1 || size > encryptChunkSize+d.aead.Overhead() {
+		return fmt.Errorf("%w: invalid chunk", errDecrypt)
+	}
+	sealed := make([]byte, size)
+	if _, err := io.ReadFull(d.r, sealed); err != nil {
+		return fmt.Errorf("%w: truncated: %w", errDecrypt, err)
+	}
+	final := record[0] == 1
+	buf, err := d.aead.Open(sealed[:0], chunkNonce(d.aead, d.counter, final), sealed, d.header)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errDecrypt, err)
+	}
+	d.buf = buf
+	d.counter++
+	d.final = final
+	return nil
+}
+
+func newAEAD(key, salt []byte) (cipher.AEAD, error) {
+	objectKey, err := hkdf.Key(sha256.New, key, salt, "etcd-wrapper backup", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(objectKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(aead cipher.AEAD, counter uint64, final bool) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	binary.BigEndian.PutUint64(nonce, counter)
+	if final {
+		nonce[len(nonce)-1] = 1
+	}
+	return nonce
+}
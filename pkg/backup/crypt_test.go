@@ -0,0 +1,125 @@
+package backup
+
+import (
+	"bytes"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"testing"
+)
+
+func mockEncryptionConfig() *c.Config {
+	return &c.Config{
+		BackupEncryptionKeyID: "key-2",
+		BackupEncryptionKeys: map[string][]byte{
+			"key-1": bytes.Repeat([]byte{1}, 32),
+			"key-2": bytes.Repeat([]byte{2}, 32),
+		},
+	}
+}
+
+func encrypt(t *testing.T, config *c.Config, data []byte) []byte {
+	reader := encryptReader(config, bytes.NewReader(data))
+	defer reader.Close()
+	encrypted, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	return encrypted
+}
+
+func decrypt(config *c.Config, data []byte) ([]byte, string, error) {
+	reader, id, err := decryptReader(config, bytes.NewReader(data))
+	if err != nil {
+		return nil, id, err
+	}
+	decrypted, err := io.ReadAll(reader)
+	return decrypted, id, err
+}
+
+func TestCrypt(t *testing.T) {
+	config := mockEncryptionConfig()
+
+	for _, size := range []int{1, encryptChunkSize - 1, encryptChunkSize, encryptChunkSize + 1, 3 * encryptChunkSize} {
+		data := bytes.Repeat([]byte("a"), size)
+		encrypted := encrypt(t, config, data)
+
+		decrypted, id, err := decrypt(config, encrypted)
+		assert.NoError(t, err)
+		assert.Equal(t, "key-2", id)
+		assert.Equal(t, data, decrypted)
+	}
+}
+
+func TestCryptRotation(t *testing.T) {
+	data := []byte("test-snapshot-data")
+
+	// backup taken before rotation
+	config := mockEncryptionConfig()
+	config.BackupEncryptionKeyID = "key-1"
+	encrypted := encrypt(t, config, data)
+
+	// old key is still accepted after rotation
+	config.BackupEncryptionKeyID = "key-2"
+	decrypted, id, err := decrypt(config, encrypted)
+	assert.NoError(t, err)
+	assert.Equal(t, "key-1", id)
+	assert.Equal(t, data, decrypted)
+
+	// removed key
+	delete(config.BackupEncryptionKeys, "key-1")
+	_, id, err = decrypt(config, encrypted)
+	assert.ErrorIs(t, err, errDecrypt)
+	assert.Equal(t, "key-1", id)
+
+	// unencrypted backups pass through
+	decrypted, id, err = decrypt(config, data)
+	assert.NoError(t, err)
+	assert.Equal(t, "", id)
+	assert.Equal(t, data, decrypted)
+}
+
+func TestCryptTamper(t *testing.T) {
+	config := mockEncryptionConfig()
+	data := bytes.Repeat([]byte("test-snapshot-data-"), encryptChunkSize/8)
+	encrypted := encrypt(t, config, data)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{
+			name: "flipped byte",
+			data: func() []byte {
+				b := bytes.Clone(encrypted)
+				b[len(b)/2] ^= 1
+				return b
+			}(),
+		},
+		{
+			name: "flipped salt",
+			data: func() []byte {
+				b := bytes.Clone(encrypted)
+				b[len(encryptMagic)+1+len("key-2")] ^= 1
+				return b
+			}(),
+		},
+		{
+			name: "truncated",
+			data: encrypted[:len(encrypted)/2],
+		},
+		{
+			name: "final chunk dropped",
+			data: encrypted[:len(encryptMagic)+1+len("key-2")+encryptSaltSize+5+encryptChunkSize+16],
+		},
+		{
+			name: "trailing data",
+			data: append(bytes.Clone(encrypted), 0),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := decrypt(config, tt.data)
+			assert.ErrorIs(t, err, errDecrypt)
+		})
+	}
+}
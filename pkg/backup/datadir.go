@@ -0,0 +1,94 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
+	etcddatadir "go.etcd.io/etcd/server/v3/storage/datadir"
+	"go.uber.org/zap"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RestoreDataDirSnapshot replaces data dir with a restore of its own backend db
+// This drops membership and WAL entries not yet applied to the backend
+// Restore is written to a sibling dir and renamed over data dir only once it succeeds
+func RestoreDataDirSnapshot(ctx context.Context, config *c.Config, versionBump uint64) (err error) {
+	dataDir := config.Env["ETCD_DATA_DIR"]
+	defer func() {
+		recordRestore(config, dataDir, err)
+	}()
+	config.Logger.Info("attempting restore from existing data", zap.String("dataDir", dataDir))
+	restoreCtx, restoreCancel := context.WithTimeout(ctx, time.Duration(config.RestoreTimeout))
+	defer restoreCancel()
+
+	// sibling of data dir so that restored data can be renamed in place
+	dir, err := os.MkdirTemp(filepath.Dir(filepath.Clean(dataDir)), filepath.Base(dataDir)+".restore-*")
+	if err != nil {
+		config.Logger.Error("create path for restore failed", zap.Error(err))
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	snapshotFile, err := copyFile(etcddatadir.ToBackendFileName(dataDir), dir)
+	if err != nil {
+		config.Logger.Error("copy backend db failed", zap.Error(err))
+		return fmt.Errorf("copy backend db from %s: %w", dataDir, err)
+	}
+	if _, err := verifySnapshot(restoreCtx, config, snapshotFile, nil, s3client.ObjectInfo{Key: dataDir}); err != nil {
+		return err
+	}
+
+	restoreConfig := *config
+	restoreConfig.Env = maps.Clone(config.Env)
+	restoreConfig.Env["ETCD_DATA_DIR"] = filepath.Join(dir, "data")
+	// backend db copied from data dir has no snapshot hash appended
+	if err := restoreV3Snapshot(restoreCtx, &restoreConfig, snapshotFile, versionBump, "--skip-hash-check"); err != nil {
+		config.Logger.Error("restore snapshot failed", zap.Error(err))
+		return err
+	}
+	if err := replaceDir(restoreConfig.Env["ETCD_DATA_DIR"], dataDir, filepath.Join(dir, "previous")); err != nil {
+		config.Logger.Error("replace data dir failed", zap.Error(err))
+		return err
+	}
+	config.Logger.Info("restored from existing data")
+	return nil
+}
+
+// replaceDir moves dst out of the way to previous and renames src to dst
+// dst is moved back if the rename fails
+func replaceDir(src, dst, previous string) error {
+	if err := os.Rename(dst, previous); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		if restoreErr := os.Rename(previous, dst); restoreErr != nil {
+			return fmt.Errorf("rename %s: %w\n  failed to move back %s: %w", src, err, dst, restoreErr)
+		}
+		return fmt.Errorf("rename %s: %w", src, err)
+	}
+	return nil
+}
+
+func copyFile(src, dir string) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(dir, "snapshot-restore-*.db")
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return "", err
+	}
+	return out.Name(), out.Close()
+}
@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestoreDataDirSnapshot(t *testing.T) {
+	snapshotFile, err := filepath.Abs(filepath.Join(baseTestPath, "../test-snapshot.db"))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+	dataDir := filepath.Join(dataPath, "data")
+
+	config, err := mockConfig("datadir", dataDir)
+	assert.NoError(t, err)
+
+	err = RestoreSnapshotFrom(ctx, config, "file://"+snapshotFile, 0)
+	assert.NoError(t, err)
+
+	// --- failed restore leaves existing data --- //
+
+	initialCluster := config.Env["ETCD_INITIAL_CLUSTER"]
+	config.Env["ETCD_INITIAL_CLUSTER"] = "invalid"
+
+	err = RestoreDataDirSnapshot(ctx, config, 0)
+	assert.Error(t, err)
+	_, err = os.Stat(filepath.Join(dataDir, "member", "snap", "db"))
+	assert.NoError(t, err)
+
+	// --- restore replaces data dir --- //
+
+	config.Env["ETCD_INITIAL_CLUSTER"] = initialCluster
+
+	err = RestoreDataDirSnapshot(ctx, config, 0)
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dataDir, "member", "snap", "db"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(dataDir, "member", "wal"))
+	assert.NoError(t, err)
+
+	entries, err := os.ReadDir(dataPath)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(entries))
+}
@@ -0,0 +1,104 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
+	"go.uber.org/zap"
+	"strconv"
+	"time"
+)
+
+// BackupMetadata describes where and how a backup was taken
+// It is recorded as user metadata on the backup object and as a JSON object under InternalKey so that it can be read without downloading the backup
+// Revision is the header revision of the member just before the snapshot was taken
+type BackupMetadata struct {
+	Key             string    `json:"key"`
+	SHA256          string    `json:"sha256"`
+	Size            int64     `json:"size"`
+	SnapshotSize    int64     `json:"snapshotSize"`
+	Codec           string    `json:"codec"`
+	EncryptionKeyID string    `json:"encryptionKeyID,omitempty"`
+	ClusterID       uint64    `json:"clusterID"`
+	MemberID        uint64    `json:"memberID"`
+	Revision        int64     `json:"revision"`
+	EtcdVersion     string    `json:"etcdVersion"`
+	WrapperVersion  string    `json:"wrapperVersion"`
+	CreatedAt       time.Time `json:"createdAt"`
+}
+
+// Backup is a listed backup object with metadata if recorded
+type Backup struct {
+	s3client.ObjectInfo
+	Metadata *BackupMetadata `json:"metadata,omitempty"`
+}
+
+func metadataKey(key string) string {
+	return internalKeyPrefix + key + ".json"
+}
+
+// userMetadata returns fields known before upload for S3 user metadata
+// SHA-256 is added by the S3 client once the object is spooled
+func (m *BackupMetadata) userMetadata() map[string]string {
+	metadata := map[string]string{
+		codecMetadataKey:  m.Codec,
+		"Cluster-Id":      fmt.Sprintf("%x", m.ClusterID),
+		"Member-Id":       fmt.Sprintf("%x", m.MemberID),
+		"Revision":        strconv.FormatInt(m.Revision, 10),
+		"Etcd-Version":    m.EtcdVersion,
+		"Wrapper-Version": m.WrapperVersion,
+	}
+	if m.EncryptionKeyID != "" {
+		metadata[encryptionMetadataKey] = m.EncryptionKeyID
+	}
+	return metadata
+}
+
+// ReadMetadata returns recorded metadata for backup object. Metadata is nil for backups taken before metadata was recorded
+func ReadMetadata(ctx context.Context, config *c.Config, s3 s3client.Client, object s3client.ObjectInfo) (*BackupMetadata, error) {
+	b, etag, err := s3.Read(ctx, config, metadataKey(object.Key))
+	if err != nil {
+		return nil, err
+	}
+	if etag == "" {
+		return nil, nil
+	}
+	var metadata BackupMetadata
+	if err := json.Unmarshal(b, &metadata); err != nil {
+		return nil, fmt.Errorf("%w: %s: invalid metadata: %w", errSnapshotRejected, object.Key, err)
+	}
+	if metadata.Key != object.Key {
+		return nil, fmt.Errorf("%w: %s: metadata is for key %s", errSnapshotRejected, object.Key, metadata.Key)
+	}
+	if object.Size > 0 && metadata.Size != object.Size {
+		return nil, fmt.Errorf("%w: %s: metadata size %d does not match object size %d", errSnapshotRejected, object.Key, metadata.Size, object.Size)
+	}
+	return &metadata, nil
+}
+
+// ListBackups returns backup objects in key order with their metadata
+// Backups with metadata that does not match the object are logged and listed without metadata
+func ListBackups(ctx context.Context, config *c.Config, s3 s3client.Client) []Backup {
+	var backups []Backup
+	for _, object := range s3.ListInfo(ctx, config) {
+		metadata, err := ReadMetadata(ctx, config, s3, object)
+		if err != nil {
+			config.Logger.Error("read backup metadata failed", zap.String("key", object.Key), zap.Error(err))
+		}
+		backups = append(backups, Backup{
+			ObjectInfo: object,
+			Metadata:   metadata,
+		})
+	}
+	return backups
+}
+
+func writeMetadata(ctx context.Context, config *c.Config, s3 s3client.Client, metadata *BackupMetadata) error {
+	b, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s3.Write(ctx, config, metadataKey(metadata.Key), b)
+}
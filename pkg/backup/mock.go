@@ -1,9 +1,12 @@
 package backup
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
 	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
 	"github.com/randomcoww/etcd-wrapper/pkg/tlsutil"
 	"go.uber.org/zap"
 	"io"
@@ -54,13 +57,23 @@ func mockConfig(prefixKey, dataPath string) (*c.Config, error) {
 	return config, nil
 }
 
-type mockS3 struct{}
+type mockS3 struct {
+	manifest  []byte
+	etag      string
+	objects   []s3client.ObjectInfo // defaults to a single dummy key
+	content   map[string][]byte     // defaults to test snapshot
+	downloads int
+}
 
 func (c *mockS3) Verify(ctx context.Context, config *c.Config) error {
 	return nil
 }
 
 func (m *mockS3) Download(ctx context.Context, config *c.Config, key string, handler func(context.Context, io.Reader) error) (bool, error) {
+	m.downloads++
+	if b, ok := m.content[key]; ok {
+		return true, handler(ctx, bytes.NewReader(b))
+	}
 	file, err := os.Open(filepath.Join(baseTestPath, "../test-snapshot.db"))
 	if err != nil {
 		return false, err
@@ -69,8 +82,12 @@ func (m *mockS3) Download(ctx context.Context, config *c.Config, key string, han
 	return true, handler(ctx, file)
 }
 
-func (c *mockS3) Upload(ctx context.Context, config *c.Config, key string, reader io.Reader) (int64, error) {
-	return 10, nil
+func (c *mockS3) Upload(ctx context.Context, config *c.Config, key string, reader io.Reader, metadata map[string]string) (int64, string, error) {
+	return 10, "", nil
+}
+
+func (c *mockS3) Write(ctx context.Context, config *c.Config, key string, data []byte) error {
+	return nil
 }
 
 func (c *mockS3) Remove(ctx context.Context, config *c.Config, keys []string) error {
@@ -78,7 +95,58 @@ func (c *mockS3) Remove(ctx context.Context, config *c.Config, keys []string) er
 }
 
 func (c *mockS3) List(ctx context.Context, config *c.Config) []string {
-	return []string{
-		"dummy", // just need non-zero keys
+	var keys []string
+	for _, object := range c.ListInfo(ctx, config) {
+		keys = append(keys, object.Key)
+	}
+	return keys
+}
+
+func (c *mockS3) ListInfo(ctx context.Context, config *c.Config) []s3client.ObjectInfo {
+	if c.objects != nil {
+		return c.objects
+	}
+	return []s3client.ObjectInfo{
+		{Key: "dummy", Size: mockSnapshotSize(), LastModified: time.Now()}, // just need non-zero keys
+	}
+}
+
+func mockSnapshotSize() int64 {
+	info, err := os.Stat(filepath.Join(baseTestPath, "../test-snapshot.db"))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (c *mockS3) Read(ctx context.Context, config *c.Config, key string) ([]byte, string, error) {
+	if key != restoreManifestKey(config) {
+		if b, ok := c.content[key]; ok {
+			return b, fmt.Sprintf("%x", sha256.Sum256(b)), nil
+		}
+		return nil, "", nil
+	}
+	return c.manifest, c.etag, nil
+}
+
+func (c *mockS3) WriteIfMatch(ctx context.Context, config *c.Config, key string, data []byte, etag string) (bool, error) {
+	if etag != c.etag {
+		return false, nil
+	}
+	c.manifest = data
+	c.etag = fmt.Sprintf("%x", sha256.Sum256(data))
+	return true, nil
+}
+
+// mockS3NotFound returns not found for one key
+type mockS3NotFound struct {
+	mockS3
+	notFound string
+}
+
+func (m *mockS3NotFound) Download(ctx context.Context, config *c.Config, key string, handler func(context.Context, io.Reader) error) (bool, error) {
+	if key == m.notFound {
+		return false, nil
 	}
+	return m.mockS3.Download(ctx, config, key, handler)
 }
@@ -2,43 +2,134 @@ package backup
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/metrics"
 	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
 	"go.uber.org/zap"
 	"io"
 	"os"
 	"os/exec"
+	"slices"
 	"time"
 )
 
-func RestoreSnapshot(ctx context.Context, config *c.Config, s3 s3client.Client, versionBump uint64) (bool, error) {
-	keys := s3.List(ctx, config)
-	if len(keys) == 0 {
-		return false, nil
+var errRevisionAbove = errors.New("snapshot revision is above restore revision")
+
+// RestoreSnapshot restores the selected or newest snapshot that succeeds and returns its key. Key is empty if no backups exist
+func RestoreSnapshot(ctx context.Context, config *c.Config, s3 s3client.Client, versionBump uint64) (string, error) {
+	objects, err := RestoreCandidates(ctx, config, s3)
+	if err != nil || len(objects) == 0 {
+		return "", err
 	}
-	var ok bool
-	var err error
 
-	for i := len(keys) - 1; i >= 0; i-- {
-		ok, err = restoreSnapshotKey(ctx, config, s3, keys[i], versionBump)
+	for _, object := range objects {
+		key := object.Key
+		var ok bool
+		ok, err = restoreSnapshotKey(ctx, config, s3, object, versionBump)
 		if err == nil && ok {
-			config.Logger.Info("restored snapshot success", zap.String("key", keys[i]))
-			break
+			recordRestore(config, key, nil)
+			config.Logger.Info("restored snapshot success", zap.String("key", key))
+			return key, nil
+		}
+		if err == nil {
+			err = fmt.Errorf("snapshot %s not found", key)
+		}
+		recordRestore(config, key, err)
+		if errors.Is(err, errRevisionAbove) {
+			config.Logger.Info("skipping snapshot", zap.String("key", key), zap.Error(err))
+			continue
+		}
+		config.Logger.Error("restore failed", zap.String("key", key), zap.Error(err))
+		if selectedSnapshot(config) && !config.RestoreFallback {
+			return "", fmt.Errorf("restore selected snapshot %s failed and fallback is not enabled: %w", key, err)
 		}
-		config.Logger.Error("restore failed", zap.String("key", keys[i]), zap.Error(err))
 	}
-	if err != nil {
-		return false, fmt.Errorf("all restore failed %w", err)
+	return "", fmt.Errorf("all restore failed %w", err)
+}
+
+func recordRestore(config *c.Config, key string, err error) {
+	outcome := metrics.Outcome(err)
+	switch {
+	case errors.Is(err, errSnapshotRejected):
+		outcome = metrics.OutcomeRejected
+	case errors.Is(err, errRevisionAbove):
+		outcome = metrics.OutcomeSkipped
 	}
-	return true, nil
+	metrics.RestoreAttempt(config, key, outcome)
 }
 
-func restoreSnapshotKey(ctx context.Context, config *c.Config, s3 s3client.Client, key string, versionBump uint64) (bool, error) {
+func selectedSnapshot(config *c.Config) bool {
+	return config.RestoreKey != "" || !config.RestoreBefore.IsZero() || config.RestoreRevision > 0
+}
+
+// RestoreCandidates returns backups to attempt restore from in order
+// If a restore selection is set, keys not matching are left out and an error is returned if none match
+// Timestamp selection uses the time recorded in backup metadata and falls back to the upload time
+// Revision selection is checked on restore since revision is only known exactly from the snapshot
+func RestoreCandidates(ctx context.Context, config *c.Config, s3 s3client.Client) ([]s3client.ObjectInfo, error) {
+	objects := slices.Clone(s3.ListInfo(ctx, config))
+	slices.Reverse(objects)
+
+	switch {
+	case config.RestoreKey != "":
+		i := slices.IndexFunc(objects, func(object s3client.ObjectInfo) bool {
+			return object.Key == config.RestoreKey
+		})
+		if i < 0 {
+			return nil, fmt.Errorf("restore key %s not found", config.RestoreKey)
+		}
+		objects = objects[i:]
+
+	case !config.RestoreBefore.IsZero():
+		createdAt := make(map[string]time.Time)
+		for _, object := range objects {
+			createdAt[object.Key] = backupCreatedAt(ctx, config, s3, object)
+		}
+		objects = slices.DeleteFunc(objects, func(object s3client.ObjectInfo) bool {
+			return createdAt[object.Key].After(config.RestoreBefore)
+		})
+		slices.SortStableFunc(objects, func(a, b s3client.ObjectInfo) int {
+			return createdAt[b.Key].Compare(createdAt[a.Key])
+		})
+		if len(objects) == 0 {
+			return nil, fmt.Errorf("no backup found at or before %s", config.RestoreBefore.Format(time.RFC3339))
+		}
+
+	case config.RestoreRevision > 0:
+		if len(objects) == 0 {
+			return nil, fmt.Errorf("no backup found at or below revision %d", config.RestoreRevision)
+		}
+	}
+
+	return objects, nil
+}
+
+// backupCreatedAt returns the time snapshot was taken from backup metadata or upload time if not recorded
+func backupCreatedAt(ctx context.Context, config *c.Config, s3 s3client.Client, object s3client.ObjectInfo) time.Time {
+	metadata, err := ReadMetadata(ctx, config, s3, object)
+	if err != nil || metadata == nil || metadata.CreatedAt.IsZero() {
+		return object.LastModified
+	}
+	return metadata.CreatedAt
+}
+
+func restoreSnapshotKey(ctx context.Context, config *c.Config, s3 s3client.Client, object s3client.ObjectInfo, versionBump uint64) (bool, error) {
 	config.Logger.Info("attempting snapshot restore")
 	restoreCtx, restoreCancel := context.WithTimeout(ctx, time.Duration(config.RestoreTimeout))
 	defer restoreCancel()
 
+	// metadata revision is taken before the snapshot so snapshot revision is at least this
+	if config.RestoreRevision > 0 {
+		metadata, err := ReadMetadata(restoreCtx, config, s3, object)
+		if err == nil && metadata != nil && metadata.Revision > config.RestoreRevision {
+			return false, fmt.Errorf("%w: metadata revision %d > %d", errRevisionAbove, metadata.Revision, config.RestoreRevision)
+		}
+	}
+
 	dir, err := os.MkdirTemp("", "etcd-wrapper-*")
 	if err != nil {
 		config.Logger.Error("create path for snapshot failed", zap.Error(err))
@@ -46,42 +137,102 @@ func restoreSnapshotKey(ctx context.Context, config *c.Config, s3 s3client.Clien
 	}
 	defer os.RemoveAll(dir)
 
+	snapshotFile, stored, ok, err := downloadSnapshot(restoreCtx, config, s3, object, dir)
+	if err != nil || !ok {
+		return ok, err
+	}
+	status, err := verifySnapshot(restoreCtx, config, snapshotFile, stored, object)
+	if err != nil {
+		return false, err
+	}
+	if config.RestoreRevision > 0 && status.Revision > config.RestoreRevision {
+		return false, fmt.Errorf("%w: %d > %d", errRevisionAbove, status.Revision, config.RestoreRevision)
+	}
+	if err := restoreV3Snapshot(restoreCtx, config, snapshotFile, versionBump); err != nil {
+		config.Logger.Error("restore snapshot failed", zap.Error(err))
+		return false, err
+	}
+	config.Logger.Info("finished restoring snapshot")
+	return true, nil
+}
+
+// storedObject is the backup object as downloaded before decrypt and decompress
+type storedObject struct {
+	size     int64
+	sha256   string
+	metadata *BackupMetadata
+}
+
+// downloadSnapshot writes decrypted and decompressed snapshot to dir and returns what was downloaded from S3
+func downloadSnapshot(ctx context.Context, config *c.Config, s3 s3client.Client, object s3client.ObjectInfo, dir string) (string, *storedObject, bool, error) {
+	metadata, err := ReadMetadata(ctx, config, s3, object)
+	if err != nil {
+		config.Logger.Error("read backup metadata failed", zap.String("key", object.Key), zap.Error(err))
+		return "", nil, false, err
+	}
+	if metadata != nil {
+		config.Logger.Info("found backup metadata", zap.String("key", object.Key), zap.String("clusterID", fmt.Sprintf("%x", metadata.ClusterID)), zap.String("memberID", fmt.Sprintf("%x", metadata.MemberID)), zap.Int64("revision", metadata.Revision), zap.String("etcdVersion", metadata.EtcdVersion))
+	}
+
 	snapshotFile, err := os.CreateTemp(dir, "snapshot-restore-*.db")
 	if err != nil {
 		config.Logger.Error("open file for snapshot failed", zap.Error(err))
-		return false, err
+		return "", nil, false, err
 	}
-	defer os.RemoveAll(snapshotFile.Name())
 	defer snapshotFile.Close()
 	config.Logger.Info("opened file for snapshot")
 
-	ok, err := s3.Download(restoreCtx, config, key, func(ctx context.Context, reader io.Reader) error {
-		b, err := io.Copy(snapshotFile, reader)
+	hash := sha256.New()
+	stored := &countingReader{}
+	ok, err := s3.Download(ctx, config, object.Key, func(ctx context.Context, reader io.Reader) error {
+		stored.Reader = io.TeeReader(reader, hash)
+		body, err := openSnapshot(config, stored)
+		if err != nil {
+			return err
+		}
+		defer body.Close()
+
+		b, err := io.Copy(snapshotFile, body)
 		if err != nil {
 			return err
 		}
 		if b == 0 {
 			return fmt.Errorf("snapshot file download size was 0")
 		}
-		return nil
+		// drain trailing bytes so that stored size covers the whole object
+		_, err = io.Copy(io.Discard, stored)
+		return err
 	})
 	if err != nil {
 		config.Logger.Error("download snapshot failed", zap.Error(err))
-		return false, err
+		return "", nil, false, err
 	}
 	if !ok {
 		config.Logger.Info("no snapshots found")
-		return false, nil
+		return "", nil, false, nil
 	}
-	if err := restoreV3Snapshot(restoreCtx, config, snapshotFile.Name(), versionBump); err != nil {
-		config.Logger.Error("restore snapshot failed", zap.Error(err))
-		return false, err
+	return snapshotFile.Name(), &storedObject{
+		size:     stored.n,
+		sha256:   hex.EncodeToString(hash.Sum(nil)),
+		metadata: metadata,
+	}, true, nil
+}
+
+// openSnapshot decrypts and decompresses snapshot as detected from its content
+func openSnapshot(config *c.Config, reader io.Reader) (io.ReadCloser, error) {
+	decrypted, keyID, err := decryptReader(config, reader)
+	if err != nil {
+		return nil, err
 	}
-	config.Logger.Info("finished restoring snapshot")
-	return true, nil
+	body, codec, err := decompressReader(decrypted)
+	if err != nil {
+		return nil, err
+	}
+	config.Logger.Info("opened snapshot", zap.String("codec", codec), zap.String("encryptionKeyID", keyID))
+	return body, nil
 }
 
-func restoreV3Snapshot(ctx context.Context, config *c.Config, snapshotFile string, versionBump uint64) error {
+func restoreV3Snapshot(ctx context.Context, config *c.Config, snapshotFile string, versionBump uint64, extraArgs ...string) error {
 	c := exec.CommandContext(ctx, config.EtcdutlBinaryFile)
 	c.Args = []string{
 		config.EtcdutlBinaryFile,
@@ -96,6 +247,7 @@ func restoreV3Snapshot(ctx context.Context, config *c.Config, snapshotFile strin
 	if versionBump > 0 {
 		c.Args = append(c.Args, "--mark-compacted")
 	}
+	c.Args = append(c.Args, extraArgs...)
 	c.Env = config.WriteEnv()
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
@@ -3,8 +3,13 @@ package backup
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdversion"
 	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
 	"github.com/stretchr/testify/assert"
+	"io"
 	"os"
 	"path/filepath"
 	"testing"
@@ -22,9 +27,9 @@ func TestRestoreSnapshot(t *testing.T) {
 	defer cancel()
 
 	minioClient := &mockS3{}
-	ok, err := RestoreSnapshot(ctx, config, minioClient, 0)
+	key, err := RestoreSnapshot(ctx, config, minioClient, 0)
 	assert.NoError(t, err)
-	assert.True(t, ok)
+	assert.Equal(t, "dummy", key)
 }
 
 func TestRestore(t *testing.T) {
@@ -51,17 +56,17 @@ func TestRestore(t *testing.T) {
 
 	// --- add test data --- //
 
-	_, err = minioClient.Upload(ctx, config, config.S3BackupKeyPrefix+"1.db", file)
+	_, _, err = minioClient.Upload(ctx, config, config.S3BackupKeyPrefix+"1.db", file, nil)
 	assert.NoError(t, err)
 
-	_, err = minioClient.Upload(ctx, config, config.S3BackupKeyPrefix+"2.db", bytes.NewBufferString("random-bad-data"))
+	_, _, err = minioClient.Upload(ctx, config, config.S3BackupKeyPrefix+"2.db", bytes.NewBufferString("random-bad-data"), nil)
 	assert.NoError(t, err)
 
 	// -- test restoring it -- //
 
-	ok, err := RestoreSnapshot(ctx, config, minioClient, 10000)
+	key, err := RestoreSnapshot(ctx, config, minioClient, 10000)
 	assert.NoError(t, err)
-	assert.True(t, ok)
+	assert.Equal(t, config.S3BackupKeyPrefix+"1.db", key)
 
 	// --- cleanup --- //
 
@@ -71,3 +76,339 @@ func TestRestore(t *testing.T) {
 	})
 	assert.NoError(t, err)
 }
+
+func TestRestoreCandidates(t *testing.T) {
+	config, err := mockConfig("restore", "")
+	assert.NoError(t, err)
+
+	now := time.Now()
+	s3 := &mockS3{
+		objects: []s3client.ObjectInfo{
+			{Key: "1.db", Size: 10, LastModified: now.Add(-3 * time.Hour)},
+			{Key: "2.db", Size: 10, LastModified: now.Add(-2 * time.Hour)},
+			{Key: "3.db", Size: 10, LastModified: now.Add(-1 * time.Hour)},
+		},
+	}
+	ctx := context.Background()
+
+	objects, err := RestoreCandidates(ctx, config, s3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3.db", "2.db", "1.db"}, objectKeys(objects))
+
+	// --- key --- //
+
+	config.RestoreKey = "2.db"
+	objects, err = RestoreCandidates(ctx, config, s3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2.db", "1.db"}, objectKeys(objects))
+
+	config.RestoreKey = "4.db"
+	_, err = RestoreCandidates(ctx, config, s3)
+	assert.Error(t, err)
+	config.RestoreKey = ""
+
+	// --- timestamp --- //
+
+	config.RestoreBefore = now.Add(-90 * time.Minute)
+	objects, err = RestoreCandidates(ctx, config, s3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2.db", "1.db"}, objectKeys(objects))
+
+	config.RestoreBefore = now.Add(-4 * time.Hour)
+	_, err = RestoreCandidates(ctx, config, s3)
+	assert.Error(t, err)
+
+	// --- timestamp from metadata over upload time --- //
+
+	b, err := json.Marshal(&BackupMetadata{Key: "3.db", Size: 10, CreatedAt: now.Add(-100 * time.Minute)})
+	assert.NoError(t, err)
+	s3.content = map[string][]byte{
+		metadataKey("3.db"): b,
+	}
+	config.RestoreBefore = now.Add(-90 * time.Minute)
+	objects, err = RestoreCandidates(ctx, config, s3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"3.db", "2.db", "1.db"}, objectKeys(objects))
+}
+
+func TestRestoreSnapshotRevision(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	config, err := mockConfig("restore", dataPath)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	// test snapshot is at revision 3
+	config.RestoreRevision = 2
+	_, err = RestoreSnapshot(ctx, config, &mockS3{}, 0)
+	assert.Error(t, err)
+
+	config.RestoreRevision = 3
+	key, err := RestoreSnapshot(ctx, config, &mockS3{}, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "dummy", key)
+
+	// --- metadata revision above is skipped without download --- //
+
+	b, err := json.Marshal(&BackupMetadata{Key: "dummy", Size: mockSnapshotSize(), Revision: 5})
+	assert.NoError(t, err)
+	s3 := &mockS3{
+		content: map[string][]byte{
+			metadataKey("dummy"): b,
+		},
+	}
+	_, err = RestoreSnapshot(ctx, config, s3, 0)
+	assert.ErrorIs(t, err, errRevisionAbove)
+	assert.Equal(t, 0, s3.downloads)
+}
+
+func TestRestoreSnapshotFallback(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	config, err := mockConfig("restore", dataPath)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	s3 := &mockS3NotFound{
+		mockS3: mockS3{
+			objects: []s3client.ObjectInfo{
+				{Key: "1.db", Size: mockSnapshotSize(), LastModified: time.Now()},
+				{Key: "2.db", Size: mockSnapshotSize(), LastModified: time.Now()},
+			},
+		},
+		notFound: "2.db",
+	}
+
+	config.RestoreKey = "2.db"
+	_, err = RestoreSnapshot(ctx, config, s3, 0)
+	assert.Error(t, err)
+
+	config.RestoreFallback = true
+	key, err := RestoreSnapshot(ctx, config, s3, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.db", key)
+}
+
+func objectKeys(objects []s3client.ObjectInfo) []string {
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	return keys
+}
+
+func TestRestoreSnapshotVerify(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	config, err := mockConfig("restore", dataPath)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	snapshot, err := os.ReadFile(filepath.Join(baseTestPath, "../test-snapshot.db"))
+	assert.NoError(t, err)
+	corrupt := bytes.Repeat([]byte("random-bad-data-"), len(snapshot)/16)
+
+	s3 := &mockS3{
+		objects: []s3client.ObjectInfo{
+			{Key: "1.db", Size: int64(len(snapshot)), LastModified: time.Now()},
+			{Key: "2.db", Size: int64(len(corrupt)), LastModified: time.Now()},
+			{Key: "3.db", Size: int64(len(snapshot)), LastModified: time.Now()},
+		},
+		content: map[string][]byte{
+			"2.db": corrupt,
+			"3.db": snapshot[:len(snapshot)/2], // truncated
+		},
+	}
+
+	key, err := RestoreSnapshot(ctx, config, s3, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.db", key)
+
+	// selected snapshot is rejected without fallback
+	config.RestoreKey = "3.db"
+	_, err = RestoreSnapshot(ctx, config, s3, 0)
+	assert.ErrorIs(t, err, errSnapshotRejected)
+}
+
+func TestRestoreSnapshotVersion(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	config, err := mockConfig("restore", dataPath)
+	assert.NoError(t, err)
+	config.VersionCheck = etcdversion.CheckEnforce
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	s3 := &mockS3{
+		objects: []s3client.ObjectInfo{
+			{Key: "1.db", Size: mockSnapshotSize(), LastModified: time.Now()},
+		},
+	}
+
+	// test snapshot is from 3.6 which can be upgraded by one minor version
+	config.EtcdVersion = etcdversion.Version{Major: 3, Minor: 7, Patch: 1}
+	key, err := RestoreSnapshot(ctx, config, s3, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.db", key)
+
+	// older etcd can not read newer data
+	config.EtcdVersion = etcdversion.Version{Major: 3, Minor: 5, Patch: 0}
+	_, err = RestoreSnapshot(ctx, config, s3, 0)
+	assert.ErrorIs(t, err, errSnapshotRejected)
+
+	os.RemoveAll(dataPath)
+	config.VersionCheck = etcdversion.CheckWarn
+	key, err = RestoreSnapshot(ctx, config, s3, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "1.db", key)
+}
+
+func TestRestoreSnapshotEncoded(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	config, err := mockConfig("restore", dataPath)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	snapshot, err := os.ReadFile(filepath.Join(baseTestPath, "../test-snapshot.db"))
+	assert.NoError(t, err)
+
+	for _, codec := range []string{CodecGzip, CodecZstd} {
+		config.BackupCompression = codec
+		reader := compressReader(config, bytes.NewReader(snapshot))
+		compressed, err := io.ReadAll(reader)
+		reader.Close()
+		assert.NoError(t, err)
+
+		if codec == CodecZstd {
+			encryption := mockEncryptionConfig()
+			config.BackupEncryptionKeyID = encryption.BackupEncryptionKeyID
+			config.BackupEncryptionKeys = encryption.BackupEncryptionKeys
+			compressed = encrypt(t, config, compressed)
+		}
+
+		key := "1.db" + codecSuffix[codec]
+		s3 := &mockS3{
+			objects: []s3client.ObjectInfo{
+				{Key: key, Size: int64(len(compressed)), LastModified: time.Now()},
+			},
+			content: map[string][]byte{
+				key: compressed,
+			},
+		}
+
+		os.RemoveAll(dataPath)
+		restored, err := RestoreSnapshot(ctx, config, s3, 0)
+		assert.NoError(t, err)
+		assert.Equal(t, key, restored)
+	}
+}
+
+func TestRestoreSnapshotMetadata(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	config, err := mockConfig("restore", dataPath)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	snapshot, err := os.ReadFile(filepath.Join(baseTestPath, "../test-snapshot.db"))
+	assert.NoError(t, err)
+
+	metadata := func(key string, modify func(*BackupMetadata)) []byte {
+		m := &BackupMetadata{
+			Key:      key,
+			SHA256:   fmt.Sprintf("%x", sha256.Sum256(snapshot)),
+			Size:     int64(len(snapshot)),
+			Revision: 3,
+		}
+		if modify != nil {
+			modify(m)
+		}
+		b, _ := json.Marshal(m)
+		return b
+	}
+
+	tests := []struct {
+		name     string
+		metadata []byte
+		wantErr  bool
+	}{
+		{
+			name: "no metadata",
+		},
+		{
+			name:     "valid",
+			metadata: metadata("1.db", nil),
+		},
+		{
+			name: "revision taken before snapshot",
+			metadata: metadata("1.db", func(m *BackupMetadata) {
+				m.Revision = 2
+			}),
+		},
+		{
+			name: "checksum mismatch",
+			metadata: metadata("1.db", func(m *BackupMetadata) {
+				m.SHA256 = fmt.Sprintf("%x", sha256.Sum256(nil))
+			}),
+			wantErr: true,
+		},
+		{
+			name: "revision above snapshot",
+			metadata: metadata("1.db", func(m *BackupMetadata) {
+				m.Revision = 4
+			}),
+			wantErr: true,
+		},
+		{
+			name:     "metadata for other key",
+			metadata: metadata("2.db", nil),
+			wantErr:  true,
+		},
+		{
+			name:     "invalid metadata",
+			metadata: []byte("{"),
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s3 := &mockS3{
+				objects: []s3client.ObjectInfo{
+					{Key: "1.db", Size: int64(len(snapshot)), LastModified: time.Now()},
+				},
+				content: map[string][]byte{},
+			}
+			if tt.metadata != nil {
+				s3.content[metadataKey("1.db")] = tt.metadata
+			}
+
+			os.RemoveAll(dataPath)
+			key, err := RestoreSnapshot(ctx, config, s3, 0)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, errSnapshotRejected)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "1.db", key)
+		})
+	}
+}
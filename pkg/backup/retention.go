@@ -0,0 +1,130 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/metrics"
+	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
+	"go.uber.org/zap"
+	"slices"
+	"strings"
+	"time"
+)
+
+// RetentionDecision is whether a backup is kept and the tiers that kept it or the reason it is pruned
+type RetentionDecision struct {
+	s3client.ObjectInfo
+	Keep    bool     `json:"keep"`
+	Reasons []string `json:"reasons"`
+}
+
+// retentionTier keeps the newest backup in each of the latest count periods returned by bucket
+// Nil bucket counts every backup
+type retentionTier struct {
+	name   string
+	count  int
+	bucket func(time.Time) string
+}
+
+// Retention decides which backups to keep by object timestamp
+// Newest S3BackupCount backups are kept along with the newest backup of each of the latest hourly, daily, weekly and monthly periods
+// Backups older than RetainMaxAge are pruned even if a tier keeps them. The newest backup is always kept
+func Retention(config *c.Config, objects []s3client.ObjectInfo, now time.Time) []RetentionDecision {
+	decisions := make([]RetentionDecision, len(objects))
+	for i, object := range objects {
+		decisions[i].ObjectInfo = object
+	}
+	// newest first. Keys are timestamped so they order backups uploaded within the same timestamp
+	slices.SortStableFunc(decisions, func(a, b RetentionDecision) int {
+		if n := b.LastModified.Compare(a.LastModified); n != 0 {
+			return n
+		}
+		return strings.Compare(b.Key, a.Key)
+	})
+
+	tiers := []retentionTier{
+		{name: "recent", count: config.S3BackupCount},
+		{name: "hourly", count: config.RetainHourly, bucket: func(t time.Time) string {
+			return t.Format("2006-01-02T15")
+		}},
+		{name: "daily", count: config.RetainDaily, bucket: func(t time.Time) string {
+			return t.Format("2006-01-02")
+		}},
+		{name: "weekly", count: config.RetainWeekly, bucket: func(t time.Time) string {
+			year, week := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", year, week)
+		}},
+		{name: "monthly", count: config.RetainMonthly, bucket: func(t time.Time) string {
+			return t.Format("2006-01")
+		}},
+	}
+	for _, tier := range tiers {
+		seen := make(map[string]bool)
+		for i := range decisions {
+			if len(seen) >= tier.count {
+				break
+			}
+			bucket := decisions[i].Key
+			if tier.bucket != nil {
+				bucket = tier.bucket(decisions[i].LastModified.UTC())
+			}
+			if seen[bucket] {
+				continue
+			}
+			seen[bucket] = true
+			decisions[i].Keep = true
+			decisions[i].Reasons = append(decisions[i].Reasons, tier.name)
+		}
+	}
+
+	for i := range decisions {
+		switch {
+		case i == 0:
+			if !decisions[i].Keep {
+				decisions[i].Reasons = append(decisions[i].Reasons, "newest")
+			}
+			decisions[i].Keep = true
+		case config.RetainMaxAge > 0 && now.Sub(decisions[i].LastModified) > config.RetainMaxAge:
+			decisions[i].Keep = false
+			decisions[i].Reasons = []string{"max-age"}
+		case !decisions[i].Keep:
+			decisions[i].Reasons = []string{"not-selected"}
+		}
+	}
+	return decisions
+}
+
+// pruneBackups removes backups not kept by retention along with their metadata
+// With RetainDryRun set, backups that would be removed are only logged
+func pruneBackups(ctx context.Context, config *c.Config, s3 s3client.Client) error {
+	var pruned []string
+	for _, decision := range Retention(config, s3.ListInfo(ctx, config), time.Now()) {
+		fields := []zap.Field{
+			zap.String("key", decision.Key),
+			zap.Time("lastModified", decision.LastModified),
+			zap.String("reasons", strings.Join(decision.Reasons, ",")),
+		}
+		switch {
+		case decision.Keep:
+			config.Logger.Info("retention keep backup", fields...)
+		case config.RetainDryRun:
+			config.Logger.Info("retention would prune backup", fields...)
+		default:
+			config.Logger.Info("retention prune backup", fields...)
+			pruned = append(pruned, decision.Key)
+		}
+	}
+	if len(pruned) == 0 {
+		return nil
+	}
+	if err := s3.Remove(ctx, config, pruned); err != nil {
+		return err
+	}
+	metrics.BackupsPruned(config, len(pruned))
+	// metadata of backups taken before it was recorded may not exist
+	if err := s3.Remove(ctx, config, metadataKeys(pruned)); err != nil {
+		config.Logger.Error("remove backup metadata failed", zap.Error(err))
+	}
+	return nil
+}
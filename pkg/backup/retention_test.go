@@ -0,0 +1,156 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+)
+
+func TestRetention(t *testing.T) {
+	now, _ := time.Parse(time.RFC3339, "2026-03-02T12:00:00Z")
+
+	// one backup every 10 minutes for 60 days
+	var objects []s3client.ObjectInfo
+	for ts := now.Add(-60 * 24 * time.Hour); !ts.After(now); ts = ts.Add(10 * time.Minute) {
+		objects = append(objects, s3client.ObjectInfo{
+			Key:          ts.Format(timeFormat),
+			LastModified: ts,
+		})
+	}
+
+	kept := func(decisions []RetentionDecision) []string {
+		var keys []string
+		for _, decision := range decisions {
+			if decision.Keep {
+				keys = append(keys, decision.Key)
+			}
+		}
+		return keys
+	}
+
+	tests := []struct {
+		name   string
+		config *c.Config
+		want   []string
+	}{
+		{
+			name: "recent only",
+			config: &c.Config{
+				S3BackupCount: 3,
+			},
+			want: []string{
+				"20260302-120000",
+				"20260302-115000",
+				"20260302-114000",
+			},
+		},
+		{
+			name: "tiers",
+			config: &c.Config{
+				S3BackupCount: 2,
+				RetainHourly:  3,
+				RetainDaily:   3,
+				RetainWeekly:  2,
+				RetainMonthly: 3,
+			},
+			want: []string{
+				"20260302-120000", // recent, hourly, daily, weekly, monthly
+				"20260302-115000", // recent, hourly
+				"20260302-105000", // hourly
+				"20260301-235000", // daily, weekly (sunday)
+				"20260228-235000", // daily, monthly
+				"20260131-235000", // monthly
+			},
+		},
+		{
+			name: "max age",
+			config: &c.Config{
+				S3BackupCount: 2,
+				RetainDaily:   3,
+				RetainMaxAge:  24 * time.Hour,
+			},
+			want: []string{
+				"20260302-120000",
+				"20260302-115000",
+				"20260301-235000",
+			},
+		},
+		{
+			name: "newest is always kept",
+			config: &c.Config{
+				RetainMaxAge: time.Minute,
+			},
+			want: []string{
+				"20260302-120000",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			decisions := Retention(tt.config, objects, now)
+			assert.Equal(t, len(objects), len(decisions))
+			assert.Equal(t, tt.want, kept(decisions))
+		})
+	}
+
+	// backups uploaded within the same timestamp are ordered by key
+	decisions := Retention(&c.Config{S3BackupCount: 1}, []s3client.ObjectInfo{
+		{Key: "20260302-115959", LastModified: now},
+		{Key: "20260302-120000", LastModified: now},
+	}, now)
+	assert.Equal(t, []string{"20260302-120000"}, kept(decisions))
+	assert.Equal(t, []string{"recent"}, decisions[0].Reasons)
+	assert.Equal(t, []string{"not-selected"}, decisions[1].Reasons)
+}
+
+type mockS3Retention struct {
+	mockS3
+	removed []string
+}
+
+func (m *mockS3Retention) Remove(ctx context.Context, config *c.Config, keys []string) error {
+	m.removed = append(m.removed, keys...)
+	return nil
+}
+
+func TestPruneBackups(t *testing.T) {
+	logger, _ := zap.NewProduction()
+	now := time.Now()
+	s3 := &mockS3Retention{
+		mockS3: mockS3{
+			objects: []s3client.ObjectInfo{
+				{Key: "1", LastModified: now.Add(-2 * time.Minute)},
+				{Key: "2", LastModified: now.Add(-1 * time.Minute)},
+				{Key: "3", LastModified: now},
+			},
+		},
+	}
+	config := &c.Config{
+		Logger:        logger,
+		S3BackupCount: 2,
+		RetainDryRun:  true,
+	}
+
+	err := pruneBackups(context.Background(), config, s3)
+	assert.NoError(t, err)
+	assert.Empty(t, s3.removed)
+
+	config.RetainDryRun = false
+	err = pruneBackups(context.Background(), config, s3)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", metadataKey("1")}, s3.removed)
+
+	// upload runs retention
+	s3.removed = nil
+	_, err = UploadSnapshot(context.Background(), config, s3, bytes.NewBufferString("test-data"), BackupMetadata{}, func() string {
+		return "4"
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"1", metadataKey("1")}, s3.removed)
+}
@@ -0,0 +1,113 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
+	"go.uber.org/zap"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+// RestoreSnapshotFrom restores snapshot from a file:// or http(s):// URL instead of S3
+// Unlike S3 restore, there is no fallback and any failure is returned
+func RestoreSnapshotFrom(ctx context.Context, config *c.Config, source string, versionBump uint64) (err error) {
+	defer func() {
+		recordRestore(config, source, err)
+	}()
+	config.Logger.Info("attempting snapshot restore", zap.String("source", source))
+	restoreCtx, restoreCancel := context.WithTimeout(ctx, time.Duration(config.RestoreTimeout))
+	defer restoreCancel()
+
+	dir, err := os.MkdirTemp("", "etcd-wrapper-*")
+	if err != nil {
+		config.Logger.Error("create path for snapshot failed", zap.Error(err))
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	snapshotFile, err := fetchSnapshot(restoreCtx, config, source, dir)
+	if err != nil {
+		config.Logger.Error("read snapshot source failed", zap.String("source", source), zap.Error(err))
+		return fmt.Errorf("read snapshot from %s: %w", source, err)
+	}
+	if _, err := verifySnapshot(restoreCtx, config, snapshotFile, nil, s3client.ObjectInfo{Key: source}); err != nil {
+		return err
+	}
+	if err := restoreV3Snapshot(restoreCtx, config, snapshotFile, versionBump); err != nil {
+		config.Logger.Error("restore snapshot failed", zap.Error(err))
+		return fmt.Errorf("restore snapshot from %s: %w", source, err)
+	}
+	config.Logger.Info("restored snapshot success", zap.String("source", source))
+	return nil
+}
+
+// fetchSnapshot copies snapshot from source into dir so that the source is never modified by restore
+func fetchSnapshot(ctx context.Context, config *c.Config, source, dir string) (string, error) {
+	u, err := url.Parse(source)
+	if err != nil {
+		return "", err
+	}
+	var reader io.ReadCloser
+	switch u.Scheme {
+	case "file":
+		reader, err = os.Open(u.Path)
+		if err != nil {
+			return "", err
+		}
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, source, nil)
+		if err != nil {
+			return "", err
+		}
+		client := &http.Client{
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+				DialContext: (&net.Dialer{
+					Timeout:   2 * time.Second,
+					KeepAlive: 30 * time.Second, // value taken from http.DefaultTransport
+				}).DialContext,
+				TLSHandshakeTimeout: 10 * time.Second, // value taken from http.DefaultTransport
+				TLSClientConfig:     config.RestoreFromTLSConfig,
+			},
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return "", fmt.Errorf("unexpected response %s", resp.Status)
+		}
+		reader = resp.Body
+	default:
+		return "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	defer reader.Close()
+
+	body, err := openSnapshot(config, reader)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+
+	snapshotFile, err := os.CreateTemp(dir, "snapshot-restore-*.db")
+	if err != nil {
+		return "", err
+	}
+	defer snapshotFile.Close()
+
+	b, err := io.Copy(snapshotFile, body)
+	if err != nil {
+		return "", err
+	}
+	if b == 0 {
+		return "", fmt.Errorf("snapshot file size was 0")
+	}
+	return snapshotFile.Name(), nil
+}
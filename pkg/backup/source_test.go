@@ -0,0 +1,73 @@
+package backup
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRestoreSnapshotFrom(t *testing.T) {
+	snapshotFile, err := filepath.Abs(filepath.Join(baseTestPath, "../test-snapshot.db"))
+	assert.NoError(t, err)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/snapshot.db":
+			http.ServeFile(w, r, snapshotFile)
+		case "/empty.db":
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tlsServer := httptest.NewTLSServer(server.Config.Handler)
+	defer tlsServer.Close()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(tlsServer.Certificate())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	tests := []struct {
+		name      string
+		source    string
+		tlsConfig *tls.Config
+		wantErr   bool
+	}{
+		{"file", "file://" + snapshotFile, nil, false},
+		{"http", server.URL + "/snapshot.db", nil, false},
+		{"https", tlsServer.URL + "/snapshot.db", &tls.Config{RootCAs: rootCAs}, false},
+		{"https untrusted", tlsServer.URL + "/snapshot.db", nil, true},
+		{"missing file", "file:///missing/snapshot.db", nil, true},
+		{"http not found", server.URL + "/missing.db", nil, true},
+		{"http empty", server.URL + "/empty.db", nil, true},
+		{"unsupported scheme", "s3://bucket/snapshot.db", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+			defer os.RemoveAll(dataPath)
+
+			config, err := mockConfig("restore", dataPath)
+			assert.NoError(t, err)
+			config.RestoreFromTLSConfig = tt.tlsConfig
+
+			err = RestoreSnapshotFrom(ctx, config, tt.source, 0)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			_, err = os.Stat(filepath.Join(dataPath, "member", "snap", "db"))
+			assert.NoError(t, err)
+		})
+	}
+}
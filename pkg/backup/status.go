@@ -0,0 +1,101 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdversion"
+	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
+	"go.uber.org/zap"
+	"os"
+	"os/exec"
+)
+
+var errSnapshotRejected = errors.New("snapshot rejected")
+
+type SnapshotStatus struct {
+	Hash      uint32 `json:"hash"`
+	Revision  int64  `json:"revision"`
+	TotalKey  int    `json:"totalKey"`
+	TotalSize int64  `json:"totalSize"`
+	Version   string `json:"version"`
+}
+
+func snapshotStatus(ctx context.Context, config *c.Config, snapshotFile string) (*SnapshotStatus, error) {
+	c := exec.CommandContext(ctx, config.EtcdutlBinaryFile)
+	c.Args = []string{
+		config.EtcdutlBinaryFile,
+		"snapshot", "status", snapshotFile,
+		"--write-out", "json",
+	}
+	c.Env = config.WriteEnv()
+	stdout := &bytes.Buffer{}
+	c.Stdout = stdout
+	c.Stderr = os.Stderr
+	if err := c.Run(); err != nil {
+		return nil, fmt.Errorf("etcdutl snapshot status failed: %w", err)
+	}
+	status := &SnapshotStatus{}
+	if err := json.Unmarshal(stdout.Bytes(), status); err != nil {
+		return nil, fmt.Errorf("parse snapshot status: %w", err)
+	}
+	return status, nil
+}
+
+// verifySnapshot checks downloaded snapshot against what is known about the backup before it is restored
+// Stored is the object as downloaded and is nil for snapshots not read from S3
+// Size of 0 in object is not checked
+func verifySnapshot(ctx context.Context, config *c.Config, snapshotFile string, stored *storedObject, object s3client.ObjectInfo) (*SnapshotStatus, error) {
+	reject := func(reason string, fields ...zap.Field) error {
+		config.Logger.Error("snapshot rejected", append([]zap.Field{
+			zap.String("key", object.Key),
+			zap.String("reason", reason),
+		}, fields...)...)
+		return fmt.Errorf("%w: %s: %s", errSnapshotRejected, object.Key, reason)
+	}
+
+	var metadata *BackupMetadata
+	if stored != nil {
+		if object.Size > 0 && stored.size != object.Size {
+			return nil, reject("size mismatch", zap.Int64("size", stored.size), zap.Int64("expectedSize", object.Size))
+		}
+		metadata = stored.metadata
+	}
+	if metadata != nil {
+		if stored.size != metadata.Size {
+			return nil, reject("size mismatch with metadata", zap.Int64("size", stored.size), zap.Int64("expectedSize", metadata.Size))
+		}
+		if stored.sha256 != metadata.SHA256 {
+			return nil, reject("checksum mismatch with metadata", zap.String("sha256", stored.sha256), zap.String("expectedSHA256", metadata.SHA256))
+		}
+	}
+	status, err := snapshotStatus(ctx, config, snapshotFile)
+	if err != nil {
+		return nil, reject("unreadable", zap.Error(err))
+	}
+	if status.Revision <= 0 {
+		return nil, reject("invalid revision", zap.Int64("revision", status.Revision))
+	}
+	if status.TotalKey <= 0 {
+		return nil, reject("no keys", zap.Int("totalKey", status.TotalKey))
+	}
+	// metadata revision is taken before the snapshot so snapshot can only be at or above it
+	if metadata != nil && status.Revision < metadata.Revision {
+		return nil, reject("revision below metadata", zap.Int64("revision", status.Revision), zap.Int64("expectedRevision", metadata.Revision))
+	}
+	// version recorded in snapshot is the storage version of the etcd that took it
+	if status.Version != "" && config.EtcdVersion != (etcdversion.Version{}) {
+		snapshotVersion, err := etcdversion.Parse(status.Version)
+		if err != nil {
+			return nil, reject("invalid version", zap.String("version", status.Version))
+		}
+		if err := etcdversion.Check(config.Logger, config.VersionCheck, "snapshot", config.EtcdVersion, snapshotVersion); err != nil {
+			return nil, reject("incompatible version", zap.String("version", status.Version))
+		}
+	}
+	config.Logger.Info("snapshot verified", zap.String("key", object.Key), zap.Uint32("hash", status.Hash), zap.Int64("revision", status.Revision), zap.Int("totalKey", status.TotalKey))
+	return status, nil
+}
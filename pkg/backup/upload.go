@@ -4,21 +4,59 @@ import (
 	"context"
 	"fmt"
 	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/metrics"
 	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
 	"go.uber.org/zap"
 	"io"
+	"time"
 )
 
-func UploadSnapshot(ctx context.Context, config *c.Config, s3 s3client.Client, reader io.Reader, tagFunc func() string) (int64, error) {
-	size, err := s3.Upload(ctx, config, fmt.Sprintf("%s%s", config.S3BackupKeyPrefix, tagFunc()), reader)
+// UploadSnapshot compresses, encrypts and uploads snapshot and returns metadata recorded for it
+// Source sets the cluster, member, revision and etcd version the snapshot was taken from
+// Codec is recorded in the key suffix. Metadata is recorded in object user metadata and a JSON object under InternalKey
+func UploadSnapshot(ctx context.Context, config *c.Config, s3 s3client.Client, reader io.Reader, source BackupMetadata, tagFunc func() string) (*BackupMetadata, error) {
+	metadata := &source
+	metadata.Codec = config.BackupCompression
+	if metadata.Codec == "" {
+		metadata.Codec = CodecNone
+	}
+	metadata.Key = fmt.Sprintf("%s%s%s", config.S3BackupKeyPrefix, tagFunc(), codecSuffix[metadata.Codec])
+	metadata.EncryptionKeyID = config.BackupEncryptionKeyID
+	metadata.WrapperVersion = c.WrapperVersion
+	metadata.CreatedAt = time.Now().UTC()
+
+	snapshot := &countingReader{Reader: reader}
+	compressed := compressReader(config, snapshot)
+	defer compressed.Close()
+	encrypted := encryptReader(config, compressed)
+	defer encrypted.Close()
+	size, sum, err := s3.Upload(ctx, config, metadata.Key, encrypted, metadata.userMetadata())
 	if err != nil {
 		config.Logger.Error("upload backup failed", zap.Error(err))
-		return size, err
+		return nil, err
+	}
+	metadata.Size = size
+	metadata.SnapshotSize = snapshot.n
+	metadata.SHA256 = sum
+	config.Logger.Info("uploaded backup", zap.String("key", metadata.Key), zap.String("codec", metadata.Codec), zap.String("encryptionKeyID", metadata.EncryptionKeyID), zap.Int64("snapshotSize", metadata.SnapshotSize), zap.Int64("size", size), zap.String("sha256", metadata.SHA256))
+
+	metrics.BackupUploaded(config, snapshot.n, size)
+
+	if err := writeMetadata(ctx, config, s3, metadata); err != nil {
+		config.Logger.Error("upload backup metadata failed", zap.Error(err))
+		return nil, err
+	}
+
+	if err := pruneBackups(ctx, config, s3); err != nil {
+		return metadata, err
 	}
+	return metadata, nil
+}
 
-	keys := s3.List(ctx, config)
-	if len(keys) > config.S3BackupCount {
-		return size, s3.Remove(ctx, config, keys[:len(keys)-config.S3BackupCount])
+func metadataKeys(keys []string) []string {
+	var metadataKeys []string
+	for _, key := range keys {
+		metadataKeys = append(metadataKeys, metadataKey(key))
 	}
-	return size, nil
+	return metadataKeys
 }
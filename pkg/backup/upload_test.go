@@ -3,6 +3,8 @@ package backup
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"fmt"
 	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
 	"github.com/stretchr/testify/assert"
 	"os"
@@ -32,20 +34,31 @@ func TestUploadSnapshot(t *testing.T) {
 	// --- test data --- //
 
 	baseNow, _ := time.Parse("2006-01-02", "2000-01-01")
-	_, err = UploadSnapshot(ctx, config, minioClient, bytes.NewBufferString("test-data-1"),
+	source := BackupMetadata{
+		ClusterID:   1,
+		MemberID:    2,
+		Revision:    3,
+		EtcdVersion: "3.7.1",
+	}
+	_, err = UploadSnapshot(ctx, config, minioClient, bytes.NewBufferString("test-data-1"), source,
 		func() string { return baseNow.Add(time.Duration(1 * time.Minute)).Format(timeFormat) },
 	)
 	assert.NoError(t, err)
 
-	_, err = UploadSnapshot(ctx, config, minioClient, bytes.NewBufferString("test-data-22"),
+	_, err = UploadSnapshot(ctx, config, minioClient, bytes.NewBufferString("test-data-22"), source,
 		func() string { return baseNow.Add(time.Duration(2 * time.Minute)).Format(timeFormat) },
 	)
 	assert.NoError(t, err)
 
-	_, err = UploadSnapshot(ctx, config, minioClient, bytes.NewBufferString("test-data-333"),
+	metadata, err := UploadSnapshot(ctx, config, minioClient, bytes.NewBufferString("test-data-333"), source,
 		func() string { return baseNow.Add(time.Duration(3 * time.Minute)).Format(timeFormat) },
 	)
 	assert.NoError(t, err)
+	assert.Equal(t, config.S3BackupKeyPrefix+"20000101-000300", metadata.Key)
+	assert.Equal(t, int64(len("test-data-333")), metadata.Size)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("test-data-333"))), metadata.SHA256)
+	assert.Equal(t, CodecNone, metadata.Codec)
+	assert.Equal(t, int64(3), metadata.Revision)
 
 	// --- list --- //
 
@@ -54,11 +67,29 @@ func TestUploadSnapshot(t *testing.T) {
 		config.S3BackupKeyPrefix + "20000101-000300",
 	}, minioClient.List(ctx, config))
 
+	// --- metadata --- //
+
+	backups := ListBackups(ctx, config, minioClient)
+	assert.Equal(t, 2, len(backups))
+	for _, backup := range backups {
+		assert.NotNil(t, backup.Metadata)
+		assert.Equal(t, backup.Key, backup.Metadata.Key)
+		assert.Equal(t, backup.Size, backup.Metadata.Size)
+	}
+	assert.Equal(t, metadata, backups[1].Metadata)
+
+	// metadata of pruned backup is removed
+	_, etag, err := minioClient.Read(ctx, config, metadataKey(config.S3BackupKeyPrefix+"20000101-000100"))
+	assert.NoError(t, err)
+	assert.Equal(t, "", etag)
+
 	// --- cleanup --- //
 
 	err = minioClient.Remove(ctx, config, []string{
 		config.S3BackupKeyPrefix + "20000101-000200",
 		config.S3BackupKeyPrefix + "20000101-000300",
+		metadataKey(config.S3BackupKeyPrefix + "20000101-000200"),
+		metadataKey(config.S3BackupKeyPrefix + "20000101-000300"),
 	})
 	assert.NoError(t, err)
 }
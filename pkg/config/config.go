@@ -1,27 +1,41 @@
 package config
 
 import (
+	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"flag"
 	"fmt"
+	"github.com/randomcoww/etcd-wrapper/pkg/discovery"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdversion"
+	"github.com/randomcoww/etcd-wrapper/pkg/health"
+	"github.com/randomcoww/etcd-wrapper/pkg/schedule"
 	"github.com/randomcoww/etcd-wrapper/pkg/tlsutil"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"net"
 	"net/url"
 	"os"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
 
+// WrapperVersion is set at build time with -ldflags "-X github.com/randomcoww/etcd-wrapper/pkg/config.WrapperVersion=<version>"
+var WrapperVersion = "dev"
+
 type Config struct {
 	Cmd                      string
+	Resolver                 discovery.Resolver
 	Env                      map[string]string
 	Logger                   *zap.Logger
 	LocalClientURL           string
 	InitialAdvertisePeerURLs []string
 	ClusterPeerURLs          []string
+	DiscoverySRV             string // domain initial cluster was discovered from
+	DiscoverySRVName         string
 	ClientTLSConfig          *tls.Config
 	PeerTLSConfig            *tls.Config
 	EtcdBinaryFile           string
@@ -30,13 +44,66 @@ type Config struct {
 	S3BackupBucket           string
 	S3BackupKeyPrefix        string
 	S3BackupCount            int
+	RetainHourly             int
+	RetainDaily              int
+	RetainWeekly             int
+	RetainMonthly            int
+	RetainMaxAge             time.Duration
+	RetainDryRun             bool
 	S3VerifyTimeout          time.Duration
 	S3TLSConfig              *tls.Config
 	InitialClusterTimeout    time.Duration
 	RestoreTimeout           time.Duration
 	ClientTimeout            time.Duration
 	UploadTimeout            time.Duration
+	UploadPartSize           uint64
+	UploadSpoolDir           string
+	BackupCompression        string
+	BackupCompressionLevel   int
+	BackupEncryptionKeyID    string            // key used to encrypt new backups
+	BackupEncryptionKeys     map[string][]byte // all keys accepted for decrypt by ID
 	BackupInterval           time.Duration
+	BackupScheduleSpec       string
+	BackupSchedule           schedule.Schedule // parsed from BackupScheduleSpec or every BackupInterval
+	BackupJitter             time.Duration
+	BackupMissedRun          string
+	BackupMaxInterval        time.Duration
+	BackupStateFile          string
+	DefragInterval           time.Duration
+	DefragThresholdRatio     float64
+	DefragThresholdBytes     int64
+	DefragLockKey            string
+	DefragLockTTL            time.Duration
+	Supervise                bool
+	WarmRejoin               bool
+	WarmRejoinMaxLag         uint64
+	JoinAsLearner            bool
+	LearnerPromoteTimeout    time.Duration
+	LearnerPromoteInterval   time.Duration
+	RestoreBarrierTimeout    time.Duration
+	StaleMemberTimeout       time.Duration
+	StaleMemberInterval      time.Duration
+	PlanOutput               string
+	StatusFile               string
+	StatusS3                 bool
+	RestoreFrom              string
+	RestoreFromTLSConfig     *tls.Config
+	RestoreKey               string
+	RestoreBefore            time.Time
+	RestoreRevision          int64
+	RestoreFallback          bool
+	DecommissionOnShutdown   bool
+	ShutdownTimeout          time.Duration
+	RecoverClusterID         uint64
+	RecoverSource            string
+	VersionCheck             string
+	EtcdVersion              etcdversion.Version // set at runtime from local etcd binary
+	Health                   *health.State
+	HealthListenAddress      string
+	HealthCertFile           string
+	HealthKeyFile            string
+	HealthS3Interval         time.Duration
+	ReadyBackupMaxAge        time.Duration
 }
 
 func (config *Config) MarshalLogObject(enc zapcore.ObjectEncoder) error {
@@ -45,18 +112,64 @@ func (config *Config) MarshalLogObject(enc zapcore.ObjectEncoder) error {
 	enc.AddString("LocalClientURL", config.LocalClientURL)
 	enc.AddString("InitialAdvertisePeerURLs", fmt.Sprintf("%v", config.InitialAdvertisePeerURLs))
 	enc.AddString("ClusterPeerURLs", fmt.Sprintf("%v", config.ClusterPeerURLs))
+	enc.AddString("DiscoverySRV", config.DiscoverySRV)
 	enc.AddString("EtcdBinaryFile", config.EtcdBinaryFile)
 	enc.AddString("EtcdutlBinaryFile", config.EtcdutlBinaryFile)
 	enc.AddString("S3BackupHost", config.S3BackupHost)
 	enc.AddString("S3BackupBucket", config.S3BackupBucket)
 	enc.AddString("S3BackupKeyPrefix", config.S3BackupKeyPrefix)
 	enc.AddInt("S3BackupCount", config.S3BackupCount)
+	enc.AddInt("RetainHourly", config.RetainHourly)
+	enc.AddInt("RetainDaily", config.RetainDaily)
+	enc.AddInt("RetainWeekly", config.RetainWeekly)
+	enc.AddInt("RetainMonthly", config.RetainMonthly)
+	enc.AddDuration("RetainMaxAge", config.RetainMaxAge)
+	enc.AddBool("RetainDryRun", config.RetainDryRun)
 	enc.AddDuration("S3VerifyTimeout", config.S3VerifyTimeout)
 	enc.AddDuration("InitialClusterTimeout", config.InitialClusterTimeout)
 	enc.AddDuration("RestoreTimeout", config.RestoreTimeout)
 	enc.AddDuration("ClientTimeout", config.ClientTimeout)
 	enc.AddDuration("UploadTimeout", config.UploadTimeout)
+	enc.AddUint64("UploadPartSize", config.UploadPartSize)
+	enc.AddString("UploadSpoolDir", config.UploadSpoolDir)
+	enc.AddString("BackupCompression", config.BackupCompression)
+	enc.AddInt("BackupCompressionLevel", config.BackupCompressionLevel)
+	enc.AddString("BackupEncryptionKeyID", config.BackupEncryptionKeyID)
 	enc.AddDuration("BackupInterval", config.BackupInterval)
+	enc.AddString("BackupSchedule", config.BackupScheduleSpec)
+	enc.AddDuration("BackupJitter", config.BackupJitter)
+	enc.AddString("BackupMissedRun", config.BackupMissedRun)
+	enc.AddDuration("BackupMaxInterval", config.BackupMaxInterval)
+	enc.AddString("BackupStateFile", config.BackupStateFile)
+	enc.AddDuration("DefragInterval", config.DefragInterval)
+	enc.AddFloat64("DefragThresholdRatio", config.DefragThresholdRatio)
+	enc.AddInt64("DefragThresholdBytes", config.DefragThresholdBytes)
+	enc.AddString("DefragLockKey", config.DefragLockKey)
+	enc.AddDuration("DefragLockTTL", config.DefragLockTTL)
+	enc.AddBool("Supervise", config.Supervise)
+	enc.AddBool("WarmRejoin", config.WarmRejoin)
+	enc.AddUint64("WarmRejoinMaxLag", config.WarmRejoinMaxLag)
+	enc.AddBool("JoinAsLearner", config.JoinAsLearner)
+	enc.AddDuration("LearnerPromoteTimeout", config.LearnerPromoteTimeout)
+	enc.AddDuration("LearnerPromoteInterval", config.LearnerPromoteInterval)
+	enc.AddDuration("RestoreBarrierTimeout", config.RestoreBarrierTimeout)
+	enc.AddDuration("StaleMemberTimeout", config.StaleMemberTimeout)
+	enc.AddDuration("StaleMemberInterval", config.StaleMemberInterval)
+	enc.AddString("StatusFile", config.StatusFile)
+	enc.AddBool("StatusS3", config.StatusS3)
+	enc.AddString("RestoreFrom", config.RestoreFrom)
+	enc.AddString("RestoreKey", config.RestoreKey)
+	enc.AddTime("RestoreBefore", config.RestoreBefore)
+	enc.AddInt64("RestoreRevision", config.RestoreRevision)
+	enc.AddBool("RestoreFallback", config.RestoreFallback)
+	enc.AddBool("DecommissionOnShutdown", config.DecommissionOnShutdown)
+	enc.AddDuration("ShutdownTimeout", config.ShutdownTimeout)
+	enc.AddString("RecoverClusterID", fmt.Sprintf("%x", config.RecoverClusterID))
+	enc.AddString("RecoverSource", config.RecoverSource)
+	enc.AddString("VersionCheck", config.VersionCheck)
+	enc.AddString("HealthListenAddress", config.HealthListenAddress)
+	enc.AddDuration("HealthS3Interval", config.HealthS3Interval)
+	enc.AddDuration("ReadyBackupMaxAge", config.ReadyBackupMaxAge)
 	return nil
 }
 
@@ -77,9 +190,18 @@ func NewConfig(cmd string, args []string) (*Config, error) {
 	return config, nil
 }
 
+func (config *Config) healthFlags(fs *flag.FlagSet) {
+	fs.StringVar(&config.HealthListenAddress, "health-listen-address", "", "Address to serve /healthz, /readyz, /status and /metrics on. Empty disables")
+	fs.StringVar(&config.HealthCertFile, "health-cert-file", "", "TLS certificate for health endpoints. Empty serves HTTP")
+	fs.StringVar(&config.HealthKeyFile, "health-key-file", "", "TLS key for health endpoints")
+	fs.DurationVar(&config.HealthS3Interval, "health-s3-interval", 1*time.Minute, "Interval to check S3 and newest backup for health endpoints")
+}
+
 func (config *Config) ParseArgs(args []string) error {
 	var (
 		s3Resource, s3CAFile string
+		restoreFromCAFile    string
+		encryptionKeyFile    string
 		err                  error
 		ok                   bool
 	)
@@ -93,22 +215,95 @@ func (config *Config) ParseArgs(args []string) error {
 	fs.StringVar(&config.EtcdutlBinaryFile, "etcdutl-binary-file", "/usr/local/bin/etcdutl", "Path to etcdutl binary")
 	fs.DurationVar(&config.ClientTimeout, "client-timeout", 8*time.Second, "Client operations timeout")
 	fs.DurationVar(&config.S3VerifyTimeout, "s3-verify-timeout", 10*time.Second, "S3 backup access verify timeout")
+	fs.StringVar(&encryptionKeyFile, "backup-encryption-key-file", "", "File of id=base64 AES-256 keys, one per line, to encrypt and decrypt backups. First key encrypts new backups. Falls back to env BACKUP_ENCRYPTION_KEYS")
 
 	switch config.Cmd {
-	case "run":
+	case "run", "plan":
 		fs.DurationVar(&config.InitialClusterTimeout, "initial-cluster-timeout", 2*time.Minute, "Initial cluster discovery timeout")
 		fs.StringVar(&config.EtcdBinaryFile, "etcd-binary-file", "/usr/local/bin/etcd", "Path to etcd binary")
 		fs.DurationVar(&config.RestoreTimeout, "restore-snapshot-timeout", 1*time.Minute, "Restore snapshot timeout")
+		fs.BoolVar(&config.Supervise, "supervise", false, "Run etcd as a child process instead of replacing the wrapper process")
+		fs.BoolVar(&config.WarmRejoin, "warm-rejoin", false, "Keep existing data dir on restart if local member is still valid in the cluster")
+		fs.Uint64Var(&config.WarmRejoinMaxLag, "warm-rejoin-max-lag", 5000, "Wipe existing data and re-add member if its commit index is more than this many entries behind leader")
+		fs.BoolVar(&config.JoinAsLearner, "join-as-learner", false, "Join existing cluster as learner and promote once caught up. Requires -supervise")
+		fs.BoolVar(&config.DecommissionOnShutdown, "decommission-on-shutdown", false, "Remove local member from cluster on shutdown instead of only moving leader. Requires -supervise")
+		fs.DurationVar(&config.ShutdownTimeout, "shutdown-timeout", 15*time.Second, "Timeout to move leader or remove member on shutdown before etcd is stopped. Keep well below the termination grace period")
+		fs.DurationVar(&config.LearnerPromoteTimeout, "learner-promote-timeout", 5*time.Minute, "Timeout for learner to catch up and be promoted")
+		fs.DurationVar(&config.LearnerPromoteInterval, "learner-promote-interval", 5*time.Second, "Interval between learner promote attempts")
+		fs.DurationVar(&config.RestoreBarrierTimeout, "restore-barrier-timeout", 0, "Timeout for all members to agree on one snapshot on full cluster restore. 0 disables")
+		fs.StringVar(&config.StatusFile, "status-file", "", "Path to write bootstrap decision record. Empty disables")
+		fs.BoolVar(&config.StatusS3, "status-s3", false, "Also write bootstrap decision record to backup bucket")
+		fs.StringVar(&config.RestoreFrom, "restore-from", "", "Restore from file:// or http(s):// snapshot URL instead of S3 backups when no members are found")
+		fs.StringVar(&restoreFromCAFile, "restore-from-trusted-ca-file", "", "Custom CA for https:// restore-from URL in addition to system roots")
+		fs.StringVar(&config.RestoreKey, "restore-key", "", "Restore this backup key instead of the newest")
+		fs.Func("restore-before", "Restore newest backup taken at or before this RFC3339 time", func(v string) error {
+			config.RestoreBefore, err = time.Parse(time.RFC3339, v)
+			return err
+		})
+		fs.Int64Var(&config.RestoreRevision, "restore-revision", 0, "Restore newest backup at or below this etcd revision")
+		fs.BoolVar(&config.RestoreFallback, "restore-fallback", false, "Try older backups if the selected backup fails to restore")
+		fs.Func("force-recover-cluster-id", "Recover from permanent quorum loss by starting a single member cluster. Must match the lost cluster ID in hex. Other members must be stopped", func(v string) error {
+			config.RecoverClusterID, err = strconv.ParseUint(v, 16, 64)
+			return err
+		})
+		fs.StringVar(&config.RecoverSource, "force-recover-source", "local", "Data to recover from (local, s3)")
+		fs.StringVar(&config.VersionCheck, "version-check", etcdversion.CheckEnforce, "Action on etcd version incompatible with cluster or backup (enforce, warn, off)")
+		if config.Cmd == "plan" {
+			fs.StringVar(&config.PlanOutput, "output", "text", "Plan output format (text, json)")
+		} else {
+			config.healthFlags(fs)
+		}
 	case "sidecar":
 		fs.DurationVar(&config.UploadTimeout, "upload-snapshot-timeout", 1*time.Minute, "Upload snapshot timeout")
-		fs.DurationVar(&config.BackupInterval, "backup-interval", 10*time.Minute, "Backup interval")
+		fs.Uint64Var(&config.UploadPartSize, "upload-part-size", 16*1024*1024, "Multipart upload part size in bytes. Memory used by upload is bounded by a few parts")
+		fs.StringVar(&config.BackupCompression, "backup-compression", "none", "Compress snapshots on upload (none, gzip, zstd). Restore detects the codec")
+		fs.IntVar(&config.BackupCompressionLevel, "backup-compression-level", 0, "Compression level. gzip 1-9, zstd 1-22. 0 uses the codec default")
+		fs.StringVar(&config.UploadSpoolDir, "upload-spool-dir", "", "Directory to spool snapshot to before upload. Empty uses the system temp dir")
+		fs.DurationVar(&config.BackupInterval, "backup-interval", 10*time.Minute, "Backup interval. Backups run at fixed multiples of interval independent of start time. Intervals that divide 24h align to midnight UTC")
+		fs.StringVar(&config.BackupScheduleSpec, "backup-schedule", "", "Cron expression (minute hour day-of-month month day-of-week), @hourly, @daily, @weekly, @monthly or @every <duration> to run backups on in place of backup-interval. Prefix with TZ=<zone> to set time zone")
+		fs.DurationVar(&config.BackupJitter, "backup-jitter", 0, "Delay each scheduled backup by a random duration up to this")
+		fs.StringVar(&config.BackupMissedRun, "backup-missed-run", schedule.MissedRunOnce, "Action on scheduled backups missed while a backup runs or sidecar is stopped (run-once, skip)")
+		fs.DurationVar(&config.BackupMaxInterval, "backup-max-interval", 0, "Skip backup if revision is unchanged since the last backup taken within this interval. 0 backs up every interval")
+		fs.StringVar(&config.BackupStateFile, "backup-state-file", "", "Path to record the last backup. Empty reads the last backup from backup metadata")
+		fs.DurationVar(&config.DefragInterval, "defrag-interval", 10*time.Minute, "Interval to check fragmentation and defragment local member. 0 disables")
+		fs.Float64Var(&config.DefragThresholdRatio, "defrag-threshold-ratio", 0.5, "Defragment when free space is above this ratio of DB size. 0 disables")
+		fs.Int64Var(&config.DefragThresholdBytes, "defrag-threshold-bytes", 0, "Defragment when free space is above this many bytes. 0 disables")
+		fs.StringVar(&config.DefragLockKey, "defrag-lock-key", "/etcd-wrapper/defrag-lock", "Key prefix in etcd used to allow one member to defragment at a time")
+		fs.DurationVar(&config.DefragLockTTL, "defrag-lock-ttl", 1*time.Minute, "Lease TTL of defragment lock. Lock is released after this if holder stops")
 		fs.IntVar(&config.S3BackupCount, "s3-backup-count", 4, "count of snapshots to retain")
+		fs.IntVar(&config.RetainHourly, "retain-hourly", 0, "Also keep newest backup of this many latest hours")
+		fs.IntVar(&config.RetainDaily, "retain-daily", 0, "Also keep newest backup of this many latest days")
+		fs.IntVar(&config.RetainWeekly, "retain-weekly", 0, "Also keep newest backup of this many latest ISO weeks")
+		fs.IntVar(&config.RetainMonthly, "retain-monthly", 0, "Also keep newest backup of this many latest months")
+		fs.DurationVar(&config.RetainMaxAge, "retain-max-age", 0, "Prune backups older than this regardless of other retention. Newest backup is always kept. 0 disables")
+		fs.BoolVar(&config.RetainDryRun, "retain-dry-run", false, "Log backups retention would prune without removing them")
+		fs.DurationVar(&config.StaleMemberTimeout, "stale-member-timeout", 0, "Remove members not in initial cluster after being unreachable for this long. Discovery SRV records are looked up again on each check. 0 disables")
+		fs.DurationVar(&config.StaleMemberInterval, "stale-member-interval", 1*time.Minute, "Interval to check for stale members. Independent of backup schedule")
+		fs.DurationVar(&config.ReadyBackupMaxAge, "ready-backup-max-age", 0, "Fail readiness if newest backup is older than this. 0 disables")
+		config.healthFlags(fs)
 	default:
 		return fmt.Errorf("unsupported command %s", config.Cmd)
 	}
 	if err := fs.Parse(args); err != nil {
 		return err
 	}
+	if (config.HealthCertFile == "") != (config.HealthKeyFile == "") {
+		return fmt.Errorf("health-cert-file and health-key-file must be set together")
+	}
+	if config.HealthListenAddress != "" && config.HealthS3Interval <= 0 {
+		return fmt.Errorf("health-s3-interval must be greater than 0")
+	}
+	encryptionKeys := os.Getenv("BACKUP_ENCRYPTION_KEYS")
+	if encryptionKeyFile != "" {
+		b, err := os.ReadFile(encryptionKeyFile)
+		if err != nil {
+			return err
+		}
+		encryptionKeys = string(b)
+	}
+	if err := config.parseEncryptionKeys(encryptionKeys); err != nil {
+		return err
+	}
 
 	u, err := url.Parse(s3Resource)
 	if err != nil {
@@ -133,20 +328,19 @@ func (config *Config) ParseArgs(args []string) error {
 	if err != nil {
 		return err
 	}
+	var restoreFromCAFiles []string
+	if restoreFromCAFile != "" {
+		restoreFromCAFiles = append(restoreFromCAFiles, restoreFromCAFile)
+	}
+	config.RestoreFromTLSConfig, err = tlsutil.TLSCAConfig(restoreFromCAFiles)
+	if err != nil {
+		return err
+	}
 	delete(config.Env, "ETCD_INITIAL_CLUSTER_STATE") // this is set internally
 	delete(config.Env, "ETCD_WAL_DIR")               // simplify with just ETCD_DATA_DIR
 
 	config.Env["ETCDCTL_API"] = "3" // used by etcdutl
 
-	if v, ok := config.Env["ETCD_INITIAL_CLUSTER"]; ok {
-		for _, member := range reList.Split(v, -1) {
-			k := reMap.Split(member, 2)
-			config.ClusterPeerURLs = append(config.ClusterPeerURLs, k[1])
-		}
-	} else {
-		return fmt.Errorf("env ETCD_INITIAL_CLUSTER not set")
-	}
-
 	peerTrustedCAFile, ok := config.Env["ETCD_PEER_TRUSTED_CA_FILE"]
 	if !ok {
 		return fmt.Errorf("env ETCD_PEER_TRUSTED_CA_FILE is required")
@@ -182,7 +376,7 @@ func (config *Config) ParseArgs(args []string) error {
 	}
 
 	switch config.Cmd {
-	case "run":
+	case "run", "plan":
 		if _, ok := config.Env["ETCD_NAME"]; !ok {
 			return fmt.Errorf("env ETCD_NAME is not set")
 		}
@@ -195,16 +389,218 @@ func (config *Config) ParseArgs(args []string) error {
 		if _, ok := config.Env["ETCD_DATA_DIR"]; !ok {
 			return fmt.Errorf("env ETCD_DATA_DIR is not set")
 		}
+		if config.JoinAsLearner && !config.Supervise {
+			return fmt.Errorf("join-as-learner requires supervise")
+		}
+		if config.DecommissionOnShutdown && !config.Supervise {
+			return fmt.Errorf("decommission-on-shutdown requires supervise")
+		}
+		if config.HealthListenAddress != "" && !config.Supervise {
+			return fmt.Errorf("health-listen-address requires supervise")
+		}
+		if config.RestoreFrom != "" {
+			u, err := url.Parse(config.RestoreFrom)
+			if err != nil {
+				return err
+			}
+			switch u.Scheme {
+			case "file", "http", "https":
+			default:
+				return fmt.Errorf("unsupported restore-from scheme %s", u.Scheme)
+			}
+		}
+		var restoreSelections int
+		for _, selected := range []bool{
+			config.RestoreFrom != "",
+			config.RestoreKey != "",
+			!config.RestoreBefore.IsZero(),
+			config.RestoreRevision > 0,
+		} {
+			if selected {
+				restoreSelections++
+			}
+		}
+		if restoreSelections > 1 {
+			return fmt.Errorf("only one of restore-from, restore-key, restore-before and restore-revision can be set")
+		}
+		switch config.RecoverSource {
+		case "", "local", "s3":
+		default:
+			return fmt.Errorf("unsupported force-recover-source %s", config.RecoverSource)
+		}
+		switch config.VersionCheck {
+		case etcdversion.CheckEnforce, etcdversion.CheckWarn, etcdversion.CheckOff:
+		default:
+			return fmt.Errorf("unsupported version-check %s", config.VersionCheck)
+		}
+		switch config.PlanOutput {
+		case "", "text", "json":
+		default:
+			return fmt.Errorf("unsupported plan output %s", config.PlanOutput)
+		}
 
 		config.Env["ETCD_LOG_OUTPUTS"] = "stdout"
 		config.Env["ETCD_ENABLE_V2"] = "false"
 		config.Env["ETCD_STRICT_RECONFIG_CHECK"] = "true"
 		config.Env["ETCD_CLIENT_CERT_AUTH"] = "true"
 		config.Env["ETCD_PEER_CLIENT_CERT_AUTH"] = "true"
+
+	case "sidecar":
+		// S3 rejects parts other than the last below 5MiB
+		if config.UploadPartSize < 5*1024*1024 {
+			return fmt.Errorf("upload-part-size must be at least 5MiB")
+		}
+		if config.BackupScheduleSpec != "" {
+			if config.BackupSchedule, err = schedule.Parse(config.BackupScheduleSpec); err != nil {
+				return err
+			}
+		} else {
+			if config.BackupInterval < time.Second {
+				return fmt.Errorf("backup-interval must be at least 1s")
+			}
+			config.BackupSchedule = schedule.Every(config.BackupInterval)
+		}
+		if config.BackupJitter < 0 {
+			return fmt.Errorf("backup-jitter must not be negative")
+		}
+		switch config.BackupMissedRun {
+		case schedule.MissedRunOnce, schedule.MissedRunSkip:
+		default:
+			return fmt.Errorf("unsupported backup-missed-run %s", config.BackupMissedRun)
+		}
+		if config.DefragThresholdRatio < 0 || config.DefragThresholdRatio >= 1 {
+			return fmt.Errorf("defrag-threshold-ratio must be at least 0 and below 1")
+		}
+		if config.DefragThresholdRatio == 0 && config.DefragThresholdBytes <= 0 {
+			return fmt.Errorf("one of defrag-threshold-ratio or defrag-threshold-bytes must be set")
+		}
+		if config.StaleMemberTimeout > 0 && config.StaleMemberInterval <= 0 {
+			return fmt.Errorf("stale-member-interval must be greater than 0")
+		}
+		if config.DefragLockTTL < time.Second {
+			return fmt.Errorf("defrag-lock-ttl must be at least 1s")
+		}
+		if config.ReadyBackupMaxAge > 0 && config.BackupMaxInterval > 0 && config.ReadyBackupMaxAge <= config.BackupMaxInterval {
+			return fmt.Errorf("ready-backup-max-age must be above backup-max-interval")
+		}
+		for _, count := range []int{config.S3BackupCount, config.RetainHourly, config.RetainDaily, config.RetainWeekly, config.RetainMonthly} {
+			if count < 0 {
+				return fmt.Errorf("backup retention counts must not be negative")
+			}
+		}
+		maxLevel := map[string]int{
+			"none": 0,
+			"gzip": 9,
+			"zstd": 22,
+		}
+		level, ok := maxLevel[config.BackupCompression]
+		if !ok {
+			return fmt.Errorf("unsupported backup-compression %s", config.BackupCompression)
+		}
+		if config.BackupCompressionLevel < 0 || config.BackupCompressionLevel > level {
+			return fmt.Errorf("backup-compression-level %d out of range for %s", config.BackupCompressionLevel, config.BackupCompression)
+		}
+	}
+
+	// initial cluster is discovered here and passed to etcd in place of discovery SRV
+	if _, ok := config.Env["ETCD_INITIAL_CLUSTER"]; !ok {
+		if domain, ok := config.Env["ETCD_DISCOVERY_SRV"]; ok {
+			config.DiscoverySRV = domain
+			config.DiscoverySRVName = config.Env["ETCD_DISCOVERY_SRV_NAME"]
+			if err := config.discoverSRV(); err != nil {
+				return err
+			}
+		}
+	}
+	delete(config.Env, "ETCD_DISCOVERY_SRV")
+	delete(config.Env, "ETCD_DISCOVERY_SRV_NAME")
+
+	if v, ok := config.Env["ETCD_INITIAL_CLUSTER"]; ok {
+		for _, member := range reList.Split(v, -1) {
+			k := reMap.Split(member, 2)
+			config.ClusterPeerURLs = append(config.ClusterPeerURLs, k[1])
+		}
+	} else {
+		return fmt.Errorf("env ETCD_INITIAL_CLUSTER or ETCD_DISCOVERY_SRV not set")
 	}
 	return nil
 }
 
+// parseEncryptionKeys reads id=base64 keys separated by newlines or commas
+// Lines starting with # are ignored
+func (config *Config) parseEncryptionKeys(data string) error {
+	config.BackupEncryptionKeyID = ""
+	config.BackupEncryptionKeys = nil
+	for _, line := range regexp.MustCompile(`[\n,]`).Split(data, -1) {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		id, encoded, ok := strings.Cut(line, "=")
+		id = strings.TrimSpace(id)
+		if !ok || id == "" {
+			return fmt.Errorf("backup encryption key must be id=base64")
+		}
+		if len(id) > 255 {
+			return fmt.Errorf("backup encryption key id %s is too long", id)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+		if err != nil {
+			return fmt.Errorf("backup encryption key %s: %w", id, err)
+		}
+		if len(key) != 32 {
+			return fmt.Errorf("backup encryption key %s must be 32 bytes", id)
+		}
+		if _, ok := config.BackupEncryptionKeys[id]; ok {
+			return fmt.Errorf("duplicate backup encryption key %s", id)
+		}
+		if config.BackupEncryptionKeys == nil {
+			config.BackupEncryptionKeyID = id
+			config.BackupEncryptionKeys = make(map[string][]byte)
+		}
+		config.BackupEncryptionKeys[id] = key
+	}
+	return nil
+}
+
+func (config *Config) discoverSRV() error {
+	ctx, cancel := context.WithTimeout(context.Background(), config.ClientTimeout)
+	defer cancel()
+
+	members, err := config.srvCluster(ctx)
+	if err != nil {
+		return err
+	}
+	config.Env["ETCD_INITIAL_CLUSTER"] = strings.Join(members, ",")
+	return nil
+}
+
+func (config *Config) srvCluster(ctx context.Context) ([]string, error) {
+	resolver := config.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	return discovery.SRVCluster(ctx, resolver, config.DiscoverySRV, config.DiscoverySRVName, config.Env["ETCD_NAME"], config.InitialAdvertisePeerURLs)
+}
+
+// ResolveClusterPeerURLs returns peer URLs of the initial cluster
+// If initial cluster was discovered from DNS SRV, records are looked up again to include members added since start
+func (config *Config) ResolveClusterPeerURLs(ctx context.Context) ([]string, error) {
+	if config.DiscoverySRV == "" {
+		return config.ClusterPeerURLs, nil
+	}
+	members, err := config.srvCluster(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var peerURLs []string
+	for _, member := range members {
+		_, peerURL, _ := strings.Cut(member, "=")
+		peerURLs = append(peerURLs, peerURL)
+	}
+	return peerURLs, nil
+}
+
 func (config *Config) WriteEnv() []string {
 	var envs []string
 	for k, v := range config.Env {
@@ -1,7 +1,9 @@
 package config
 
 import (
+	"context"
 	"github.com/stretchr/testify/assert"
+	"net"
 	"path/filepath"
 	"testing"
 	"time"
@@ -57,6 +59,9 @@ func TestRunConfig(t *testing.T) {
 	}, c.Env)
 	assert.Equal(t, "/path/etcd", c.EtcdBinaryFile)
 	assert.Equal(t, "/path/etcdutl", c.EtcdutlBinaryFile)
+	assert.Equal(t, "enforce", c.VersionCheck)
+	assert.Equal(t, uint64(5000), c.WarmRejoinMaxLag)
+	assert.Equal(t, 15*time.Second, c.ShutdownTimeout)
 	assert.Equal(t, "test-1.internal:9000", c.S3BackupHost)
 	assert.Equal(t, "bucket-1", c.S3BackupBucket)
 	assert.Equal(t, "path/etcd-0.db", c.S3BackupKeyPrefix)
@@ -90,3 +95,71 @@ func TestRunConfig(t *testing.T) {
 		"ETCD_TRUSTED_CA_FILE=" + filepath.Join(baseTestPath, "ca.crt"),
 	}, c.WriteEnv())
 }
+
+type mockResolver struct {
+	added []*net.SRV
+}
+
+func (r *mockResolver) LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	return "", append([]*net.SRV{
+		{Target: "node0.example.internal.", Port: 8080},
+		{Target: "node1.example.internal.", Port: 8080},
+	}, r.added...), nil
+}
+
+func (r *mockResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	return map[string][]string{
+		"node0.example.internal": {"10.0.0.1"},
+		"node1.example.internal": {"10.0.0.2"},
+	}[host], nil
+}
+
+func TestRunConfigDiscoverySRV(t *testing.T) {
+	var (
+		baseTestPath string = "../../test/outputs"
+		member       string = "node0"
+	)
+
+	resolver := &mockResolver{}
+	c := &Config{
+		Cmd:      "run",
+		Resolver: resolver,
+		Env: map[string]string{
+			"ETCD_NAME":                        "test",
+			"ETCD_INITIAL_ADVERTISE_PEER_URLS": "https://10.0.0.2:8080",
+			"ETCD_DISCOVERY_SRV":               "example.internal",
+			"ETCD_TRUSTED_CA_FILE":             filepath.Join(baseTestPath, "ca.crt"),
+			"ETCD_CERT_FILE":                   filepath.Join(baseTestPath, member, "client", "tls.crt"),
+			"ETCD_KEY_FILE":                    filepath.Join(baseTestPath, member, "client", "tls.key"),
+			"ETCD_PEER_TRUSTED_CA_FILE":        filepath.Join(baseTestPath, "peer-ca.crt"),
+			"ETCD_PEER_CERT_FILE":              filepath.Join(baseTestPath, member, "peer", "tls.crt"),
+			"ETCD_PEER_KEY_FILE":               filepath.Join(baseTestPath, member, "peer", "tls.key"),
+			"ETCD_DATA_DIR":                    "/data/test",
+		},
+	}
+	err := c.ParseArgs([]string{
+		"-local-client-url", "https://127.0.0.1:9080",
+		"-s3-backup-resource-prefix", "https://test-1.internal:9000/bucket-1/path/etcd-0.db",
+	})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "0=https://node0.example.internal:8080,test=https://node1.example.internal:8080", c.Env["ETCD_INITIAL_CLUSTER"])
+	assert.NotContains(t, c.Env, "ETCD_DISCOVERY_SRV")
+	assert.Equal(t, []string{
+		"https://node0.example.internal:8080",
+		"https://node1.example.internal:8080",
+	}, c.ClusterPeerURLs)
+
+	// --- members added to SRV after start --- //
+
+	resolver.added = []*net.SRV{
+		{Target: "node2.example.internal.", Port: 8080},
+	}
+	peerURLs, err := c.ResolveClusterPeerURLs(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"https://node0.example.internal:8080",
+		"https://node1.example.internal:8080",
+		"https://node2.example.internal:8080",
+	}, peerURLs)
+}
@@ -1,6 +1,8 @@
 package config
 
 import (
+	"bytes"
+	"encoding/base64"
 	"github.com/stretchr/testify/assert"
 	"path/filepath"
 	"testing"
@@ -20,6 +22,7 @@ func TestSidecarConfig(t *testing.T) {
 	t.Setenv("ETCD_PEER_TRUSTED_CA_FILE", filepath.Join(baseTestPath, "peer-ca.crt"))
 	t.Setenv("ETCD_PEER_CERT_FILE", filepath.Join(baseTestPath, member, "peer", "tls.crt"))
 	t.Setenv("ETCD_PEER_KEY_FILE", filepath.Join(baseTestPath, member, "peer", "tls.key"))
+	t.Setenv("BACKUP_ENCRYPTION_KEYS", "key-2="+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, 32))+",key-1="+base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
 
 	c, err := NewConfig("sidecar", []string{
 		"-local-client-url", "https://127.0.0.1:9080",
@@ -27,7 +30,21 @@ func TestSidecarConfig(t *testing.T) {
 		"-s3-backup-resource-prefix", "https://test-1.internal:9000/bucket-1/path/etcd-0.db",
 		"-s3-backup-trusted-ca-file", filepath.Join(baseTestPath, "minio", "certs", "CAs", "ca.crt"),
 		"-s3-backup-count", "3",
+		"-retain-daily", "7",
+		"-backup-max-interval", "30m",
+		"-backup-state-file", "/var/lib/etcd-wrapper/backup.json",
+		"-retain-monthly", "12",
+		"-retain-max-age", "8760h",
 		"-s3-verify-timeout", "1m",
+		"-health-listen-address", "127.0.0.1:8070",
+		"-ready-backup-max-age", "1h",
+		"-backup-compression", "zstd",
+		"-backup-compression-level", "3",
+		"-backup-schedule", "TZ=UTC 0 */6 * * *",
+		"-backup-jitter", "5m",
+		"-backup-missed-run", "skip",
+		"-defrag-interval", "30m",
+		"-defrag-threshold-bytes", "104857600",
 	})
 	assert.NoError(t, err)
 
@@ -46,6 +63,34 @@ func TestSidecarConfig(t *testing.T) {
 	assert.Equal(t, "bucket-1", c.S3BackupBucket)
 	assert.Equal(t, "path/etcd-0.db", c.S3BackupKeyPrefix)
 	assert.Equal(t, 3, c.S3BackupCount)
+	assert.Equal(t, 0, c.RetainHourly)
+	assert.Equal(t, 7, c.RetainDaily)
+	assert.Equal(t, 12, c.RetainMonthly)
+	assert.Equal(t, 8760*time.Hour, c.RetainMaxAge)
+	assert.False(t, c.RetainDryRun)
+	assert.Equal(t, 30*time.Minute, c.BackupMaxInterval)
+	assert.Equal(t, "/var/lib/etcd-wrapper/backup.json", c.BackupStateFile)
+	assert.Equal(t, uint64(16*1024*1024), c.UploadPartSize)
+	assert.Equal(t, "zstd", c.BackupCompression)
+	assert.Equal(t, 3, c.BackupCompressionLevel)
+	assert.Equal(t, "key-2", c.BackupEncryptionKeyID)
+	assert.Equal(t, map[string][]byte{
+		"key-1": bytes.Repeat([]byte{1}, 32),
+		"key-2": bytes.Repeat([]byte{2}, 32),
+	}, c.BackupEncryptionKeys)
+	assert.Equal(t, "TZ=UTC 0 */6 * * *", c.BackupScheduleSpec)
+	assert.Equal(t, time.Date(2026, 1, 1, 6, 0, 0, 0, time.UTC), c.BackupSchedule.Next(time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, 5*time.Minute, c.BackupJitter)
+	assert.Equal(t, "skip", c.BackupMissedRun)
+	assert.Equal(t, 30*time.Minute, c.DefragInterval)
+	assert.Equal(t, 0.5, c.DefragThresholdRatio)
+	assert.Equal(t, int64(104857600), c.DefragThresholdBytes)
+	assert.Equal(t, "/etcd-wrapper/defrag-lock", c.DefragLockKey)
+	assert.Equal(t, 1*time.Minute, c.DefragLockTTL)
+	assert.Equal(t, 1*time.Minute, c.StaleMemberInterval)
+	assert.Equal(t, "127.0.0.1:8070", c.HealthListenAddress)
+	assert.Equal(t, 1*time.Minute, c.HealthS3Interval)
+	assert.Equal(t, 1*time.Hour, c.ReadyBackupMaxAge)
 	assert.Equal(t, 1*time.Minute, c.S3VerifyTimeout)
 	assert.Equal(t, "https://127.0.0.1:9080", c.LocalClientURL)
 	assert.Equal(t, []string{
@@ -59,3 +104,34 @@ func TestSidecarConfig(t *testing.T) {
 		"ETCD_TRUSTED_CA_FILE=" + filepath.Join(baseTestPath, "ca.crt"),
 	}, c.WriteEnv())
 }
+
+func TestParseEncryptionKeys(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32))
+
+	tests := []struct {
+		name    string
+		data    string
+		keyID   string
+		wantErr bool
+	}{
+		{name: "empty", data: ""},
+		{name: "file", data: "# rotated 2026-01\nnew=" + key + "\n\nold = " + key + "\n", keyID: "new"},
+		{name: "missing id", data: "=" + key, wantErr: true},
+		{name: "short key", data: "a=" + base64.StdEncoding.EncodeToString([]byte("short")), wantErr: true},
+		{name: "invalid base64", data: "a=not-base64", wantErr: true},
+		{name: "duplicate", data: "a=" + key + ",a=" + key, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config := &Config{}
+			err := config.parseEncryptionKeys(tt.data)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.keyID, config.BackupEncryptionKeyID)
+		})
+	}
+}
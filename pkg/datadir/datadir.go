@@ -0,0 +1,90 @@
+package datadir
+
+import (
+	"fmt"
+	etcdserverpb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	etcddatadir "go.etcd.io/etcd/server/v3/storage/datadir"
+	"go.etcd.io/etcd/server/v3/storage/wal"
+	"go.etcd.io/etcd/server/v3/storage/wal/walpb"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	recoveredFromFile string = "recovered-from"
+)
+
+type Identity struct {
+	MemberID  uint64 `json:"memberID"`
+	ClusterID uint64 `json:"clusterID"`
+	Commit    uint64 `json:"commit"` // last committed raft index
+}
+
+// ReadIdentity reads member and cluster ID and commit index from WAL of an existing data dir
+func ReadIdentity(logger *zap.Logger, dataDir string) (*Identity, error) {
+	walDir := etcddatadir.ToWALDir(dataDir)
+	if !wal.Exist(walDir) {
+		return nil, fmt.Errorf("wal not found in %s", dataDir)
+	}
+	if _, err := os.Stat(etcddatadir.ToBackendFileName(dataDir)); err != nil {
+		return nil, fmt.Errorf("backend db not found in %s: %w", dataDir, err)
+	}
+
+	walSnaps, err := wal.ValidSnapshotEntries(logger, walDir)
+	if err != nil {
+		return nil, fmt.Errorf("read wal snapshot entries: %w", err)
+	}
+	walSnap := &walpb.Snapshot{}
+	if len(walSnaps) > 0 {
+		walSnap = &walpb.Snapshot{
+			Index: new(walSnaps[len(walSnaps)-1].GetIndex()),
+			Term:  new(walSnaps[len(walSnaps)-1].GetTerm()),
+		}
+	}
+
+	w, err := wal.OpenForRead(logger, walDir, walSnap)
+	if err != nil {
+		return nil, fmt.Errorf("open wal: %w", err)
+	}
+	defer w.Close()
+
+	b, state, _, err := w.ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("read wal: %w", err)
+	}
+	metadata := &etcdserverpb.Metadata{}
+	if err := proto.Unmarshal(b, metadata); err != nil {
+		return nil, fmt.Errorf("parse wal metadata: %w", err)
+	}
+	if metadata.GetNodeID() == 0 || metadata.GetClusterID() == 0 {
+		return nil, fmt.Errorf("wal metadata is missing member or cluster ID")
+	}
+	return &Identity{
+		MemberID:  metadata.GetNodeID(),
+		ClusterID: metadata.GetClusterID(),
+		Commit:    state.GetCommit(),
+	}, nil
+}
+
+// WriteRecoveredFrom records the cluster ID that data dir was force recovered from
+// It is kept in member dir so that it is removed along with the data
+func WriteRecoveredFrom(dataDir string, clusterID uint64) error {
+	return os.WriteFile(filepath.Join(etcddatadir.ToMemberDir(dataDir), recoveredFromFile), []byte(strconv.FormatUint(clusterID, 16)), 0600)
+}
+
+// ReadRecoveredFrom returns the cluster ID that data dir was force recovered from. 0 if it was not recovered
+func ReadRecoveredFrom(dataDir string) uint64 {
+	b, err := os.ReadFile(filepath.Join(etcddatadir.ToMemberDir(dataDir), recoveredFromFile))
+	if err != nil {
+		return 0
+	}
+	clusterID, err := strconv.ParseUint(strings.TrimSpace(string(b)), 16, 64)
+	if err != nil {
+		return 0
+	}
+	return clusterID
+}
@@ -0,0 +1,60 @@
+package datadir
+
+import (
+	"github.com/stretchr/testify/assert"
+	etcdserverpb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	etcddatadir "go.etcd.io/etcd/server/v3/storage/datadir"
+	"go.etcd.io/etcd/server/v3/storage/wal"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+	"os"
+	"testing"
+)
+
+func TestReadIdentity(t *testing.T) {
+	dataDir := t.TempDir()
+	logger, _ := zap.NewProduction()
+
+	// --- no data --- //
+
+	_, err := ReadIdentity(logger, dataDir)
+	assert.Error(t, err)
+
+	// --- wal without backend --- //
+
+	b, err := proto.Marshal(&etcdserverpb.Metadata{
+		NodeID:    new(uint64(0x1234)),
+		ClusterID: new(uint64(0x5678)),
+	})
+	assert.NoError(t, err)
+	w, err := wal.Create(logger, etcddatadir.ToWALDir(dataDir), b)
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+
+	_, err = ReadIdentity(logger, dataDir)
+	assert.Error(t, err)
+
+	// --- wal and backend --- //
+
+	err = os.MkdirAll(etcddatadir.ToSnapDir(dataDir), 0700)
+	assert.NoError(t, err)
+	err = os.WriteFile(etcddatadir.ToBackendFileName(dataDir), []byte{}, 0600)
+	assert.NoError(t, err)
+
+	identity, err := ReadIdentity(logger, dataDir)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0x1234), identity.MemberID)
+	assert.Equal(t, uint64(0x5678), identity.ClusterID)
+}
+
+func TestRecoveredFrom(t *testing.T) {
+	dataDir := t.TempDir()
+
+	assert.Equal(t, uint64(0), ReadRecoveredFrom(dataDir))
+
+	err := os.MkdirAll(etcddatadir.ToMemberDir(dataDir), 0700)
+	assert.NoError(t, err)
+	err = WriteRecoveredFrom(dataDir, 0x5678)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(0x5678), ReadRecoveredFrom(dataDir))
+}
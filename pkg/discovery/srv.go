@@ -0,0 +1,100 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"github.com/randomcoww/etcd-wrapper/pkg/util"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	serverService string = "etcd-server-ssl"
+	serverScheme  string = "https"
+)
+
+type Resolver interface {
+	LookupSRV(context.Context, string, string, string) (string, []*net.SRV, error)
+	LookupHost(context.Context, string) ([]string, error)
+}
+
+// SRVCluster builds initial cluster members from _etcd-server-ssl._tcp.<domain> SRV records
+// Member matching local peer URLs is named localName. Other members get index names as etcd does
+func SRVCluster(ctx context.Context, resolver Resolver, domain, serviceName, localName string, localPeerURLs []string) ([]string, error) {
+	service := serverService
+	if serviceName != "" {
+		service += "-" + serviceName
+	}
+	_, srvs, err := resolver.LookupSRV(ctx, service, "tcp", domain)
+	if err != nil {
+		return nil, fmt.Errorf("error querying DNS SRV records for _%s._tcp.%s: %w", service, domain, err)
+	}
+	if len(srvs) == 0 {
+		return nil, fmt.Errorf("no DNS SRV records found for _%s._tcp.%s", service, domain)
+	}
+
+	var (
+		tempName int
+		members  []string
+	)
+	for _, srv := range srvs {
+		// SRV records have a trailing dot but URL shouldn't
+		host := net.JoinHostPort(strings.TrimSuffix(srv.Target, "."), strconv.Itoa(int(srv.Port)))
+		name := ""
+		for _, peerURL := range localPeerURLs {
+			ok, err := sameHost(ctx, resolver, host, peerURL)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				name = localName
+				break
+			}
+		}
+		if name == "" {
+			name = strconv.Itoa(tempName)
+			tempName++
+		}
+		members = append(members, fmt.Sprintf("%s=%s://%s", name, serverScheme, host))
+	}
+	return members, nil
+}
+
+func sameHost(ctx context.Context, resolver Resolver, host, peerURL string) (bool, error) {
+	u, err := url.Parse(peerURL)
+	if err != nil {
+		return false, err
+	}
+	if u.Host == host {
+		return true, nil
+	}
+	h1, p1, err := net.SplitHostPort(host)
+	if err != nil {
+		return false, err
+	}
+	h2, p2, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		return false, err
+	}
+	if p1 != p2 {
+		return false, nil
+	}
+	addrs1, err := lookupAddrs(ctx, resolver, h1)
+	if err != nil {
+		return false, err
+	}
+	addrs2, err := lookupAddrs(ctx, resolver, h2)
+	if err != nil {
+		return false, err
+	}
+	return util.HasMatchingElement(addrs1, addrs2), nil
+}
+
+func lookupAddrs(ctx context.Context, resolver Resolver, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{ip.String()}, nil
+	}
+	return resolver.LookupHost(ctx, host)
+}
@@ -0,0 +1,125 @@
+package discovery
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/dns/dnsmessage"
+	"net"
+	"testing"
+	"time"
+)
+
+type mockRecords struct {
+	srv map[string][]dnsmessage.SRVResource
+	a   map[string][4]byte
+}
+
+// mockDNSServer answers SRV and A queries from records over UDP
+func mockDNSServer(t *testing.T, records *mockRecords) *net.Resolver {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var req dnsmessage.Message
+			if err := req.Unpack(buf[:n]); err != nil || len(req.Questions) == 0 {
+				continue
+			}
+			q := req.Questions[0]
+			resp := dnsmessage.Message{
+				Header: dnsmessage.Header{
+					ID:            req.Header.ID,
+					Response:      true,
+					Authoritative: true,
+				},
+				Questions: req.Questions,
+			}
+			header := dnsmessage.ResourceHeader{
+				Name:  q.Name,
+				Class: dnsmessage.ClassINET,
+				TTL:   60,
+			}
+			switch q.Type {
+			case dnsmessage.TypeSRV:
+				for _, srv := range records.srv[q.Name.String()] {
+					resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &srv})
+				}
+			case dnsmessage.TypeA:
+				if a, ok := records.a[q.Name.String()]; ok {
+					resp.Answers = append(resp.Answers, dnsmessage.Resource{Header: header, Body: &dnsmessage.AResource{A: a}})
+				}
+			}
+			b, err := resp.Pack()
+			if err != nil {
+				continue
+			}
+			conn.WriteTo(b, addr)
+		}
+	}()
+
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "udp", conn.LocalAddr().String())
+		},
+	}
+}
+
+func TestSRVCluster(t *testing.T) {
+	resolver := mockDNSServer(t, &mockRecords{
+		srv: map[string][]dnsmessage.SRVResource{
+			"_etcd-server-ssl._tcp.example.internal.": {
+				{Target: dnsmessage.MustNewName("node0.example.internal."), Port: 2380},
+				{Target: dnsmessage.MustNewName("node1.example.internal."), Port: 2380},
+				{Target: dnsmessage.MustNewName("node2.example.internal."), Port: 2380},
+			},
+			"_etcd-server-ssl-test._tcp.example.internal.": {
+				{Target: dnsmessage.MustNewName("node3.example.internal."), Port: 2380},
+			},
+		},
+		a: map[string][4]byte{
+			"node0.example.internal.": {10, 0, 0, 1},
+			"node1.example.internal.": {10, 0, 0, 2},
+			"node2.example.internal.": {10, 0, 0, 3},
+			"node3.example.internal.": {10, 0, 0, 4},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+	defer cancel()
+
+	// --- local member matched by IP --- //
+
+	members, err := SRVCluster(ctx, resolver, "example.internal", "", "node1", []string{"https://10.0.0.2:2380"})
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"0=https://node0.example.internal:2380",
+		"node1=https://node1.example.internal:2380",
+		"1=https://node2.example.internal:2380",
+	}, members)
+
+	// --- local member matched by host --- //
+
+	members, err = SRVCluster(ctx, resolver, "example.internal", "", "node2", []string{"https://node2.example.internal:2380"})
+	assert.NoError(t, err)
+	assert.Contains(t, members, "node2=https://node2.example.internal:2380")
+
+	// --- service name --- //
+
+	members, err = SRVCluster(ctx, resolver, "example.internal", "test", "node3", []string{"https://10.0.0.4:2380"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"node3=https://node3.example.internal:2380",
+	}, members)
+
+	// --- no records --- //
+
+	_, err = SRVCluster(ctx, resolver, "missing.internal", "", "node0", []string{"https://10.0.0.1:2380"})
+	assert.Error(t, err)
+}
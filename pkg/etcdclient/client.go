@@ -40,6 +40,11 @@ type Member interface {
 type Status interface {
 	GetHeader() *etcdserverpb.ResponseHeader
 	GetLeader() uint64
+	GetRaftIndex() uint64
+	GetIsLearner() bool
+	GetVersion() string
+	GetDbSize() int64
+	GetDbSizeInUse() int64
 }
 
 type Header interface {
@@ -52,7 +57,10 @@ type EtcdClient interface {
 	Status(context.Context, string) (Status, error)
 	MemberList(context.Context) (Members, error)
 	MemberAdd(context.Context, []string) (Members, error)
+	MemberAddAsLearner(context.Context, []string) (Members, error)
+	MemberPromote(context.Context, uint64) (Members, error)
 	MemberRemove(context.Context, uint64) (Members, error)
+	MoveLeader(context.Context, uint64) error
 	GetQuorum(context.Context) error
 	Defragment(context.Context, string) error
 	Snapshot(context.Context) (io.Reader, error)
@@ -159,6 +167,33 @@ func (client *Client) MemberAdd(ctx context.Context, peerURLs []string) (Members
 	}
 }
 
+func (client *Client) MemberAddAsLearner(ctx context.Context, peerURLs []string) (Members, error) {
+	for {
+		resp, err := client.Cluster.MemberAddAsLearner(ctx, peerURLs)
+		switch {
+		case err == nil:
+			return (*etcdserverpb.MemberAddResponse)(resp), nil
+		default:
+		}
+
+		timer := time.NewTimer(backoffWaitBetween)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-timer.C:
+			continue
+		}
+	}
+}
+
+func (client *Client) MemberPromote(ctx context.Context, id uint64) (Members, error) {
+	resp, err := client.Cluster.MemberPromote(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return (*etcdserverpb.MemberPromoteResponse)(resp), nil
+}
+
 func (client *Client) MemberRemove(ctx context.Context, id uint64) (Members, error) {
 	for {
 		resp, err := client.Cluster.MemberRemove(ctx, id)
@@ -178,6 +213,12 @@ func (client *Client) MemberRemove(ctx context.Context, id uint64) (Members, err
 	}
 }
 
+// MoveLeader must be called on a client connected only to the leader
+func (client *Client) MoveLeader(ctx context.Context, transfereeID uint64) error {
+	_, err := client.Maintenance.MoveLeader(ctx, transfereeID)
+	return err
+}
+
 func (client *Client) GetQuorum(ctx context.Context) error {
 	for {
 		_, err := client.Get(ctx, "health-check-dummy", clientv3.WithCountOnly())
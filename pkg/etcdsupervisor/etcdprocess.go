@@ -0,0 +1,175 @@
+// Run etcd as a child process and keep the wrapper running as its parent
+
+package etcdsupervisor
+
+import (
+	"fmt"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+)
+
+type EtcdSupervisor struct {
+	Cmd *exec.Cmd
+	// ShutdownHook runs once on the first SIGTERM or SIGINT before it is passed to etcd
+	// Further signals are passed immediately
+	ShutdownHook func()
+	done         chan struct{}
+	exitCode     int
+	err          error
+}
+
+var (
+	forwardSignals = []os.Signal{syscall.SIGTERM, syscall.SIGINT}
+)
+
+func (m *EtcdSupervisor) StartNew(config *c.Config) error {
+	return m.start(config, "new")
+}
+
+func (m *EtcdSupervisor) StartExisting(config *c.Config) error {
+	return m.start(config, "existing")
+}
+
+func (m *EtcdSupervisor) start(config *c.Config, clusterState string) error {
+	m.Cmd = exec.Command(config.EtcdBinaryFile)
+	m.Cmd.Args = []string{
+		config.EtcdBinaryFile,
+		"--initial-cluster-state",
+		clusterState,
+	}
+	m.Cmd.Env = config.WriteEnv()
+	m.Cmd.Stdout = os.Stdout
+	m.Cmd.Stderr = os.Stderr
+
+	// register before start so that no child exit or signal is missed
+	signals := make(chan os.Signal, 8)
+	signal.Notify(signals, forwardSignals...)
+	var childSignals chan os.Signal
+	if os.Getpid() == 1 {
+		childSignals = make(chan os.Signal, 8)
+		signal.Notify(childSignals, syscall.SIGCHLD)
+	}
+	if err := m.Cmd.Start(); err != nil {
+		signal.Stop(signals)
+		if childSignals != nil {
+			signal.Stop(childSignals)
+		}
+		return err
+	}
+	m.done = make(chan struct{})
+
+	go m.forward(signals)
+	if childSignals != nil {
+		// running as init - reap all children including orphans reparented to us
+		go m.reap(childSignals)
+	} else {
+		go m.wait()
+	}
+	return nil
+}
+
+func (m *EtcdSupervisor) forward(signals chan os.Signal) {
+	defer signal.Stop(signals)
+	hook := m.ShutdownHook
+	for {
+		select {
+		case <-m.done:
+			return
+		case sig := <-signals:
+			if hook != nil {
+				h := hook
+				hook = nil
+				go func() {
+					h()
+					m.Cmd.Process.Signal(sig)
+				}()
+				continue
+			}
+			m.Cmd.Process.Signal(sig)
+		}
+	}
+}
+
+func (m *EtcdSupervisor) wait() {
+	state, err := m.Cmd.Process.Wait()
+	if err != nil {
+		m.finish(1, err)
+		return
+	}
+	m.finish(exitCode(state.Sys().(syscall.WaitStatus)), nil)
+}
+
+func (m *EtcdSupervisor) reap(childSignals chan os.Signal) {
+	defer signal.Stop(childSignals)
+	pid := m.Cmd.Process.Pid
+	for {
+		for {
+			var ws syscall.WaitStatus
+			wpid, err := syscall.Wait4(-1, &ws, syscall.WNOHANG, nil)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || wpid <= 0 {
+				break
+			}
+			if wpid == pid {
+				m.finish(exitCode(ws), nil)
+				return
+			}
+		}
+		<-childSignals
+	}
+}
+
+func (m *EtcdSupervisor) finish(code int, err error) {
+	m.exitCode = code
+	m.err = err
+	close(m.done)
+}
+
+// Stop asks etcd to shut down gracefully
+func (m *EtcdSupervisor) Stop() error {
+	if m.Cmd == nil || m.Cmd.Process == nil {
+		return nil
+	}
+	select {
+	case <-m.done:
+		return nil
+	default:
+	}
+	return m.Cmd.Process.Signal(syscall.SIGTERM)
+}
+
+// Wait blocks until etcd exits and returns an error if it exited non-zero
+func (m *EtcdSupervisor) Wait() error {
+	if m.done == nil {
+		return nil
+	}
+	<-m.done
+	if m.err != nil {
+		return m.err
+	}
+	if m.exitCode != 0 {
+		return fmt.Errorf("etcd exited with code %d", m.exitCode)
+	}
+	return nil
+}
+
+// ExitCode is valid after Wait returns
+func (m *EtcdSupervisor) ExitCode() int {
+	return m.exitCode
+}
+
+func exitCode(ws syscall.WaitStatus) int {
+	switch {
+	case ws.Exited():
+		return ws.ExitStatus()
+	case ws.Signaled():
+		return 128 + int(ws.Signal())
+	default:
+		return 1
+	}
+}
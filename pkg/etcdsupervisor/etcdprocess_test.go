@@ -0,0 +1,72 @@
+package etcdsupervisor
+
+import (
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func mockConfig(t *testing.T, script string) *c.Config {
+	binaryFile := filepath.Join(t.TempDir(), "etcd")
+	err := os.WriteFile(binaryFile, []byte("#!/bin/sh\n"+script+"\n"), 0755)
+	assert.NoError(t, err)
+
+	return &c.Config{
+		EtcdBinaryFile: binaryFile,
+		Env:            map[string]string{},
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	config := mockConfig(t, "exit 3")
+
+	p := &EtcdSupervisor{}
+	err := p.StartNew(config)
+	assert.NoError(t, err)
+
+	err = p.Wait()
+	assert.Error(t, err)
+	assert.Equal(t, 3, p.ExitCode())
+}
+
+func TestStop(t *testing.T) {
+	config := mockConfig(t, "trap 'exit 0' TERM\nwhile true; do sleep 0.1; done")
+
+	p := &EtcdSupervisor{}
+	err := p.StartExisting(config)
+	assert.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+	err = p.Stop()
+	assert.NoError(t, err)
+
+	err = p.Wait()
+	assert.NoError(t, err)
+	assert.Equal(t, 0, p.ExitCode())
+}
+
+func TestShutdownHook(t *testing.T) {
+	config := mockConfig(t, "trap 'exit 0' TERM\nwhile true; do sleep 0.1; done")
+
+	var hookDone bool
+	p := &EtcdSupervisor{
+		ShutdownHook: func() {
+			time.Sleep(200 * time.Millisecond)
+			hookDone = true
+		},
+	}
+	err := p.StartExisting(config)
+	assert.NoError(t, err)
+
+	time.Sleep(500 * time.Millisecond)
+	err = syscall.Kill(os.Getpid(), syscall.SIGTERM)
+	assert.NoError(t, err)
+
+	err = p.Wait()
+	assert.NoError(t, err)
+	assert.True(t, hookDone)
+}
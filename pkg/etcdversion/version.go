@@ -0,0 +1,158 @@
+package etcdversion
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+type Compatibility int
+
+const (
+	// Same minor version
+	Compatible Compatibility = iota
+	// Local is one minor version ahead as in a rolling upgrade
+	Upgrade
+	Incompatible
+)
+
+const (
+	CheckEnforce string = "enforce"
+	CheckWarn    string = "warn"
+	CheckOff     string = "off"
+)
+
+const minClusterVersion string = "3.0.0"
+
+var ErrIncompatible = errors.New("incompatible etcd version")
+
+var reVersion = regexp.MustCompile(`v?(\d+)\.(\d+)(?:\.(\d+))?`)
+
+func Parse(s string) (Version, error) {
+	m := reVersion.FindStringSubmatch(s)
+	if m == nil {
+		return Version{}, fmt.Errorf("version not found in %q", s)
+	}
+	v := Version{}
+	v.Major, _ = strconv.Atoi(m[1])
+	v.Minor, _ = strconv.Atoi(m[2])
+	if m[3] != "" {
+		v.Patch, _ = strconv.Atoi(m[3])
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Compare returns whether local version can run with data or members of the other version
+// etcd supports upgrading one minor version at a time and no downgrade without the downgrade API
+func Compare(local, other Version) Compatibility {
+	switch {
+	case local.Major != other.Major:
+		return Incompatible
+	case local.Minor == other.Minor:
+		return Compatible
+	case local.Minor == other.Minor+1:
+		return Upgrade
+	default:
+		return Incompatible
+	}
+}
+
+// Check logs local version against version of other and returns an error if incompatible in enforce mode
+func Check(logger *zap.Logger, mode, other string, local, otherVersion Version) error {
+	if mode == CheckOff {
+		return nil
+	}
+	fields := []zap.Field{
+		zap.String("local", local.String()),
+		zap.String(other, otherVersion.String()),
+	}
+	switch Compare(local, otherVersion) {
+	case Compatible:
+		return nil
+	case Upgrade:
+		logger.Warn("etcd version is one minor version ahead of "+other, fields...)
+		return nil
+	}
+	if mode == CheckWarn {
+		logger.Warn("etcd version is incompatible with "+other, fields...)
+		return nil
+	}
+	logger.Error("etcd version is incompatible with "+other, fields...)
+	return fmt.Errorf("%w: local %s, %s %s", ErrIncompatible, local, other, otherVersion)
+}
+
+// Binary returns version of etcd or etcdutl binary
+func Binary(ctx context.Context, binaryFile string, args ...string) (Version, error) {
+	out, err := exec.CommandContext(ctx, binaryFile, args...).Output()
+	if err != nil {
+		return Version{}, fmt.Errorf("get version of %s: %w", binaryFile, err)
+	}
+	// first line has the binary version. Later lines may have API and Go versions
+	line, _, _ := strings.Cut(string(out), "\n")
+	return Parse(line)
+}
+
+// Cluster returns cluster version from the first peer that responds to /version
+func Cluster(ctx context.Context, tlsConfig *tls.Config, peerURLs []string) (Version, error) {
+	client := &http.Client{
+		Timeout: 2 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	var err error
+	for _, peerURL := range peerURLs {
+		var v Version
+		v, err = peerClusterVersion(ctx, client, peerURL)
+		if err == nil {
+			return v, nil
+		}
+	}
+	return Version{}, fmt.Errorf("get cluster version from peers: %w", err)
+}
+
+func peerClusterVersion(ctx context.Context, client *http.Client, peerURL string) (Version, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(peerURL, "/")+"/version", nil)
+	if err != nil {
+		return Version{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Version{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return Version{}, fmt.Errorf("unexpected response %s", resp.Status)
+	}
+	versions := struct {
+		Server  string `json:"etcdserver"`
+		Cluster string `json:"etcdcluster"`
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		return Version{}, err
+	}
+	// cluster version is not decided until members agree and starts at the minimum of 3.0.0
+	if versions.Cluster == "" || versions.Cluster == "not_decided" || versions.Cluster == minClusterVersion {
+		return Parse(versions.Server)
+	}
+	return Parse(versions.Cluster)
+}
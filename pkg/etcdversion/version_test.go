@@ -0,0 +1,96 @@
+package etcdversion
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Version
+		wantErr bool
+	}{
+		{"3.6.0", Version{3, 6, 0}, false},
+		{"3.6", Version{3, 6, 0}, false},
+		{"etcd Version: 3.7.1", Version{3, 7, 1}, false},
+		{"etcdutl version: v3.5.12", Version{3, 5, 12}, false},
+		{"unknown", Version{}, true},
+	}
+	for _, tt := range tests {
+		v, err := Parse(tt.in)
+		if tt.wantErr {
+			assert.Error(t, err)
+			continue
+		}
+		assert.NoError(t, err)
+		assert.Equal(t, tt.want, v)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		local, other Version
+		want         Compatibility
+	}{
+		{Version{3, 6, 1}, Version{3, 6, 0}, Compatible},
+		{Version{3, 6, 0}, Version{3, 6, 5}, Compatible},
+		{Version{3, 7, 0}, Version{3, 6, 0}, Upgrade},
+		{Version{3, 5, 0}, Version{3, 6, 0}, Incompatible},
+		{Version{3, 7, 0}, Version{3, 5, 0}, Incompatible},
+		{Version{4, 0, 0}, Version{3, 6, 0}, Incompatible},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, Compare(tt.local, tt.other), "%s with %s", tt.local, tt.other)
+	}
+}
+
+func TestCluster(t *testing.T) {
+	decided := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"etcdserver":"3.7.1","etcdcluster":"3.6.0"}`))
+	}))
+	defer decided.Close()
+
+	notDecided := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"etcdserver":"3.7.1","etcdcluster":"not_decided"}`))
+	}))
+	defer notDecided.Close()
+
+	minimum := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"etcdserver":"3.7.1","etcdcluster":"3.0.0"}`))
+	}))
+	defer minimum.Close()
+
+	ctx := context.Background()
+
+	v, err := Cluster(ctx, nil, []string{"http://127.0.0.1:1", decided.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, Version{3, 6, 0}, v)
+
+	v, err = Cluster(ctx, nil, []string{notDecided.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, Version{3, 7, 1}, v)
+
+	v, err = Cluster(ctx, nil, []string{minimum.URL})
+	assert.NoError(t, err)
+	assert.Equal(t, Version{3, 7, 1}, v)
+
+	_, err = Cluster(ctx, nil, []string{"http://127.0.0.1:1"})
+	assert.Error(t, err)
+}
+
+func TestCheck(t *testing.T) {
+	logger := zap.NewNop()
+	local := Version{3, 7, 1}
+
+	assert.NoError(t, Check(logger, CheckEnforce, "cluster", local, Version{3, 7, 0}))
+	assert.NoError(t, Check(logger, CheckEnforce, "cluster", local, Version{3, 6, 0}))
+	assert.ErrorIs(t, Check(logger, CheckEnforce, "cluster", local, Version{3, 5, 0}), ErrIncompatible)
+	assert.ErrorIs(t, Check(logger, CheckEnforce, "cluster", local, Version{3, 8, 0}), ErrIncompatible)
+	assert.NoError(t, Check(logger, CheckWarn, "cluster", local, Version{3, 5, 0}))
+	assert.NoError(t, Check(logger, CheckOff, "cluster", local, Version{4, 0, 0}))
+}
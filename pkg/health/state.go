@@ -0,0 +1,148 @@
+// Wrapper state reported by health endpoints
+// Methods are safe to call on a nil State so that callers need not check if health is enabled
+
+package health
+
+import (
+	"sync"
+	"time"
+)
+
+type State struct {
+	mu         sync.Mutex
+	started    time.Time
+	bootstrap  Bootstrap
+	lastBackup Backup
+	s3         S3
+}
+
+type Bootstrap struct {
+	State    string    `json:"state"`
+	Decision string    `json:"decision,omitempty"`
+	Reason   string    `json:"reason,omitempty"`
+	Time     time.Time `json:"time"`
+	Done     bool      `json:"done"`
+	Error    string    `json:"error,omitempty"`
+}
+
+type Backup struct {
+	Time        time.Time `json:"time,omitempty"`
+	Key         string    `json:"key,omitempty"`
+	Skipped     bool      `json:"skipped,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	LastSuccess time.Time `json:"lastSuccess,omitempty"`
+}
+
+// S3 is the result of the last S3 check so that probes do not call S3 on every request
+type S3 struct {
+	Time         time.Time     `json:"time,omitempty"`
+	Reachable    bool          `json:"reachable"`
+	Error        string        `json:"error,omitempty"`
+	NewestBackup *NewestBackup `json:"newestBackup,omitempty"`
+}
+
+type NewestBackup struct {
+	Key          string    `json:"key"`
+	Size         int64     `json:"size"`
+	LastModified time.Time `json:"lastModified"`
+	Revision     int64     `json:"revision,omitempty"`
+}
+
+func NewState() *State {
+	return &State{
+		started: time.Now(),
+	}
+}
+
+func (s *State) Started() time.Time {
+	if s == nil {
+		return time.Time{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.started
+}
+
+// SetBootstrap records the current bootstrap state and decision
+func (s *State) SetBootstrap(state, decision, reason string, done bool, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bootstrap = Bootstrap{
+		State:    state,
+		Decision: decision,
+		Reason:   reason,
+		Time:     time.Now(),
+		Done:     done,
+	}
+	if err != nil {
+		s.bootstrap.Error = err.Error()
+	}
+}
+
+func (s *State) Bootstrap() Bootstrap {
+	if s == nil {
+		return Bootstrap{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bootstrap
+}
+
+// SetBackup records result of a backup attempt. Empty key with no error means backup was skipped
+func (s *State) SetBackup(key string, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.lastBackup.Time = now
+	s.lastBackup.Key = key
+	s.lastBackup.Skipped = key == "" && err == nil
+	s.lastBackup.Error = ""
+	if err != nil {
+		s.lastBackup.Error = err.Error()
+		return
+	}
+	if key != "" {
+		s.lastBackup.LastSuccess = now
+	}
+}
+
+func (s *State) Backup() Backup {
+	if s == nil {
+		return Backup{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastBackup
+}
+
+// SetS3 records result of an S3 check and the newest backup found. Newest backup is kept from the last check if S3 is not reachable
+func (s *State) SetS3(newest *NewestBackup, err error) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.s3.Time = time.Now()
+	s.s3.Reachable = err == nil
+	s.s3.Error = ""
+	if err != nil {
+		s.s3.Error = err.Error()
+		return
+	}
+	s.s3.NewestBackup = newest
+}
+
+func (s *State) S3() S3 {
+	if s == nil {
+		return S3{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.s3
+}
@@ -0,0 +1,40 @@
+package health
+
+import (
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestState(t *testing.T) {
+	var nilState *State
+	nilState.SetBackup("key", nil)
+	assert.Equal(t, Backup{}, nilState.Backup())
+
+	s := NewState()
+	s.SetBootstrap("Restore", "restore", "no-members", false, nil)
+	assert.Equal(t, "Restore", s.Bootstrap().State)
+	assert.False(t, s.Bootstrap().Done)
+
+	s.SetBackup("backup-1", nil)
+	assert.Equal(t, "backup-1", s.Backup().Key)
+	success := s.Backup().LastSuccess
+	assert.False(t, success.IsZero())
+
+	s.SetBackup("", errors.New("upload failed"))
+	assert.Equal(t, "upload failed", s.Backup().Error)
+	assert.Equal(t, success, s.Backup().LastSuccess)
+
+	s.SetBackup("", nil)
+	assert.True(t, s.Backup().Skipped)
+	assert.Empty(t, s.Backup().Error)
+
+	s.SetS3(&NewestBackup{Key: "backup-1"}, nil)
+	assert.True(t, s.S3().Reachable)
+	assert.Equal(t, "backup-1", s.S3().NewestBackup.Key)
+
+	s.SetS3(nil, errors.New("bucket not found"))
+	assert.False(t, s.S3().Reachable)
+	assert.Equal(t, "bucket not found", s.S3().Error)
+	assert.Equal(t, "backup-1", s.S3().NewestBackup.Key)
+}
@@ -0,0 +1,167 @@
+// Prometheus metrics for backup, restore and membership operations
+// All metrics are labelled with cluster and member so that metrics from every member can be aggregated
+
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"net/http"
+	"time"
+)
+
+const (
+	namespace string = "etcd_wrapper"
+
+	OutcomeSuccess  string = "success"
+	OutcomeFailed   string = "failed"
+	OutcomeSkipped  string = "skipped"
+	OutcomeRejected string = "rejected"
+)
+
+var (
+	identityLabels = []string{"cluster", "member"}
+
+	registry = prometheus.NewRegistry()
+
+	backupRuns = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backup_runs_total",
+		Help:      "Backup runs by outcome",
+	}, append(identityLabels, "outcome"))
+
+	backupDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "backup_duration_seconds",
+		Help:      "Duration of backup runs by outcome",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 12),
+	}, append(identityLabels, "outcome"))
+
+	snapshotSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "backup_snapshot_size_bytes",
+		Help:      "Size of uploaded snapshots",
+		Buckets:   prometheus.ExponentialBuckets(1<<20, 2, 14),
+	}, identityLabels)
+
+	uploadBytes = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backup_upload_bytes_total",
+		Help:      "Bytes uploaded to backup bucket",
+	}, identityLabels)
+
+	prunedObjects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "backup_pruned_objects_total",
+		Help:      "Backups removed by retention",
+	}, identityLabels)
+
+	lastBackup = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backup_last_success_timestamp_seconds",
+		Help:      "Unix time of the last successful backup",
+	}, identityLabels)
+
+	restoreAttempts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "restore_attempts_total",
+		Help:      "Snapshot restore attempts by key and outcome",
+	}, append(identityLabels, "key", "outcome"))
+
+	memberOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "member_operations_total",
+		Help:      "Member add, remove and promote operations by outcome",
+	}, append(identityLabels, "operation", "outcome"))
+
+	defragDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "defragment_duration_seconds",
+		Help:      "Duration of defragment by outcome",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, append(identityLabels, "outcome"))
+
+	defragReclaimed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "defragment_reclaimed_bytes_total",
+		Help:      "Database bytes reclaimed by defragment",
+	}, identityLabels)
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		backupRuns,
+		backupDuration,
+		snapshotSize,
+		uploadBytes,
+		prunedObjects,
+		lastBackup,
+		restoreAttempts,
+		memberOperations,
+		defragDuration,
+		defragReclaimed,
+	)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// identity returns cluster and member label values
+// Cluster is the initial cluster token if set and otherwise the backup key prefix which is unique per cluster
+func identity(config *c.Config) []string {
+	cluster := config.Env["ETCD_INITIAL_CLUSTER_TOKEN"]
+	if cluster == "" {
+		cluster = config.S3BackupKeyPrefix
+	}
+	member := config.Env["ETCD_NAME"]
+	if member == "" {
+		member = config.LocalClientURL
+	}
+	return []string{cluster, member}
+}
+
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeFailed
+	}
+	return OutcomeSuccess
+}
+
+func BackupRun(config *c.Config, outcome string, duration time.Duration) {
+	labels := append(identity(config), outcome)
+	backupRuns.WithLabelValues(labels...).Inc()
+	backupDuration.WithLabelValues(labels...).Observe(duration.Seconds())
+	if outcome == OutcomeSuccess {
+		lastBackup.WithLabelValues(identity(config)...).SetToCurrentTime()
+	}
+}
+
+// BackupUploaded records snapshot size before compression and bytes uploaded after
+func BackupUploaded(config *c.Config, size, uploaded int64) {
+	snapshotSize.WithLabelValues(identity(config)...).Observe(float64(size))
+	uploadBytes.WithLabelValues(identity(config)...).Add(float64(uploaded))
+}
+
+func BackupsPruned(config *c.Config, count int) {
+	prunedObjects.WithLabelValues(identity(config)...).Add(float64(count))
+}
+
+func RestoreAttempt(config *c.Config, key, outcome string) {
+	restoreAttempts.WithLabelValues(append(identity(config), key, outcome)...).Inc()
+}
+
+func MemberOperation(config *c.Config, operation string, err error) {
+	memberOperations.WithLabelValues(append(identity(config), operation, Outcome(err))...).Inc()
+}
+
+func Defragment(config *c.Config, duration time.Duration, reclaimed int64, err error) {
+	defragDuration.WithLabelValues(append(identity(config), Outcome(err))...).Observe(duration.Seconds())
+	if err == nil && reclaimed > 0 {
+		defragReclaimed.WithLabelValues(identity(config)...).Add(float64(reclaimed))
+	}
+}
@@ -0,0 +1,62 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	config := &c.Config{
+		Env: map[string]string{
+			"ETCD_NAME":                  "node0",
+			"ETCD_INITIAL_CLUSTER_TOKEN": "test",
+		},
+	}
+
+	BackupRun(config, OutcomeSuccess, time.Second)
+	BackupRun(config, OutcomeFailed, time.Second)
+	BackupUploaded(config, 400, 100)
+	BackupsPruned(config, 2)
+	RestoreAttempt(config, "1.db", OutcomeRejected)
+	MemberOperation(config, "add", nil)
+	MemberOperation(config, "add", errors.New("failed"))
+	Defragment(config, time.Second, 50, nil)
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(backupRuns.WithLabelValues("test", "node0", OutcomeSuccess)))
+	assert.Equal(t, float64(100), testutil.ToFloat64(uploadBytes.WithLabelValues("test", "node0")))
+	assert.Equal(t, float64(2), testutil.ToFloat64(prunedObjects.WithLabelValues("test", "node0")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(restoreAttempts.WithLabelValues("test", "node0", "1.db", OutcomeRejected)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(memberOperations.WithLabelValues("test", "node0", "add", OutcomeFailed)))
+	assert.Equal(t, float64(50), testutil.ToFloat64(defragReclaimed.WithLabelValues("test", "node0")))
+	assert.Greater(t, testutil.ToFloat64(lastBackup.WithLabelValues("test", "node0")), float64(0))
+
+	// backup key prefix identifies cluster when token is not set
+	sidecarConfig := &c.Config{
+		Env:               map[string]string{},
+		S3BackupKeyPrefix: "path/etcd-0.db",
+		LocalClientURL:    "https://127.0.0.1:8080",
+	}
+	BackupRun(sidecarConfig, OutcomeSkipped, time.Second)
+	assert.Equal(t, float64(1), testutil.ToFloat64(backupRuns.WithLabelValues("path/etcd-0.db", "https://127.0.0.1:8080", OutcomeSkipped)))
+
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, name := range []string{
+		"etcd_wrapper_backup_runs_total",
+		"etcd_wrapper_backup_duration_seconds",
+		"etcd_wrapper_backup_snapshot_size_bytes",
+		"etcd_wrapper_backup_last_success_timestamp_seconds",
+		"etcd_wrapper_restore_attempts_total",
+		"etcd_wrapper_member_operations_total",
+		"etcd_wrapper_defragment_duration_seconds",
+	} {
+		assert.True(t, strings.Contains(body, name), name)
+	}
+}
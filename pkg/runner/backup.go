@@ -2,11 +2,16 @@ package runner
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"github.com/randomcoww/etcd-wrapper/pkg/backup"
 	c "github.com/randomcoww/etcd-wrapper/pkg/config"
 	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
+	"github.com/randomcoww/etcd-wrapper/pkg/metrics"
 	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
 	"go.uber.org/zap"
+	"io/fs"
+	"os"
 	"time"
 )
 
@@ -16,6 +21,7 @@ const (
 
 func RunBackup(ctx context.Context, config *c.Config, s3 s3client.Client) error {
 	defer config.Logger.Sync()
+	start := time.Now()
 
 	// wait for existing cluster (and quorum)
 	clientCtx, clientCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
@@ -24,6 +30,7 @@ func RunBackup(ctx context.Context, config *c.Config, s3 s3client.Client) error
 	client, err := etcdclient.NewClientFromPeersWithQuorum(clientCtx, config)
 	if err != nil {
 		config.Logger.Error("get client failed", zap.Error(err))
+		recordBackup(config, start, "", err)
 		return err
 	}
 	defer client.Close()
@@ -34,6 +41,7 @@ func RunBackup(ctx context.Context, config *c.Config, s3 s3client.Client) error
 	status, err := client.Status(statusCtx, config.LocalClientURL)
 	if err != nil {
 		config.Logger.Error("get local node status failed", zap.Error(err))
+		recordBackup(config, start, "", err)
 		return err
 	}
 	config.Logger.Info("local node responds to status")
@@ -41,36 +49,126 @@ func RunBackup(ctx context.Context, config *c.Config, s3 s3client.Client) error
 	config.Logger.Info("node", zap.Int64("ID", int64(status.GetHeader().GetMemberId())))
 	config.Logger.Info("leader", zap.Int64("ID", int64(status.GetLeader())))
 
-	defragCtx, defragCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
-	defer defragCancel()
-
-	if err := client.Defragment(defragCtx, config.LocalClientURL); err != nil {
-		config.Logger.Error("run defragment failed", zap.Error(err))
-		return err
+	if status.GetHeader().GetMemberId() == status.GetLeader() {
+		if last := lastBackup(ctx, config, s3); unchangedSinceBackup(config, status, last) {
+			config.Logger.Info("skipping backup with unchanged revision", zap.String("key", last.Key), zap.Int64("revision", last.Revision), zap.Time("createdAt", last.CreatedAt))
+			recordBackup(config, start, "", nil)
+		} else {
+			key, err := createBackup(ctx, config, client, s3)
+			recordBackup(config, start, key, err)
+			if err != nil {
+				return err
+			}
+		}
+	} else {
+		config.Logger.Info("skipping backup on non leader")
+		recordBackup(config, start, "", nil)
 	}
-	config.Logger.Info("defragment success")
 
-	if status.GetHeader().GetMemberId() != status.GetLeader() {
-		config.Logger.Info("skipping backup on non leader")
-		return nil
+	return nil
+}
+
+func recordBackup(config *c.Config, start time.Time, key string, err error) {
+	config.Health.SetBackup(key, err)
+	outcome := metrics.Outcome(err)
+	if err == nil && key == "" {
+		outcome = metrics.OutcomeSkipped
 	}
+	metrics.BackupRun(config, outcome, time.Since(start))
+}
 
-	// continue to run backup if leader
+func createBackup(ctx context.Context, config *c.Config, client etcdclient.EtcdClient, s3 s3client.Client) (string, error) {
 	uploadCtx, uploadCancel := context.WithTimeout(ctx, time.Duration(config.UploadTimeout))
 	defer uploadCancel()
 
+	// snapshot stream carries no header so revision is taken just before as a lower bound
+	status, err := client.Status(uploadCtx, config.LocalClientURL)
+	if err != nil {
+		config.Logger.Error("get local node status failed", zap.Error(err))
+		return "", err
+	}
 	reader, err := client.Snapshot(uploadCtx)
 	if err != nil {
 		config.Logger.Error("create backup snapshot failed", zap.Error(err))
-		return err
+		return "", err
 	}
-	if err := backup.UploadSnapshot(uploadCtx, config, s3, reader, func() string {
+	metadata, err := backup.UploadSnapshot(uploadCtx, config, s3, reader, backup.BackupMetadata{
+		ClusterID:   status.GetHeader().GetClusterId(),
+		MemberID:    status.GetHeader().GetMemberId(),
+		Revision:    status.GetHeader().GetRevision(),
+		EtcdVersion: status.GetVersion(),
+	}, func() string {
 		return time.Now().Format(timeFormat)
-	}); err != nil {
+	})
+	if err != nil {
 		config.Logger.Error("upload backup snapshot failed", zap.Error(err))
-		return err
+		return "", err
+	}
+	config.Logger.Info("created backup", zap.String("key", metadata.Key), zap.Int64("revision", metadata.Revision))
+
+	if config.BackupStateFile != "" {
+		b, err := json.Marshal(metadata)
+		if err == nil {
+			err = writeFileAtomic(config.BackupStateFile, b)
+		}
+		if err != nil {
+			config.Logger.Error("write backup state file failed", zap.Error(err))
+		}
+	}
+
+	return metadata.Key, nil
+}
+
+// unchangedSinceBackup returns true if last backup is of the current cluster and revision and was taken within BackupMaxInterval
+// Lease and membership changes do not change revision so these are only backed up once BackupMaxInterval passes
+func unchangedSinceBackup(config *c.Config, status etcdclient.Status, last *backup.BackupMetadata) bool {
+	if config.BackupMaxInterval == 0 || last == nil {
+		return false
+	}
+	return last.ClusterID == status.GetHeader().GetClusterId() &&
+		last.Revision == status.GetHeader().GetRevision() &&
+		time.Since(last.CreatedAt) < config.BackupMaxInterval
+}
+
+// lastBackup returns metadata of the last backup from BackupStateFile if set and otherwise from the newest backup
+func lastBackup(ctx context.Context, config *c.Config, s3 s3client.Client) *backup.BackupMetadata {
+	if config.BackupMaxInterval == 0 {
+		return nil
+	}
+	if last := readBackupState(config); last != nil {
+		return last
+	}
+	if newest := newestBackup(ctx, config, s3); newest != nil {
+		return newest.Metadata
 	}
-	config.Logger.Info("created backup")
+	return nil
+}
 
+// LastBackupTime returns when the newest backup was taken or zero time if no backup is found
+// Backup schedule uses this to catch up a run missed while sidecar was not running
+func LastBackupTime(ctx context.Context, config *c.Config, s3 s3client.Client) time.Time {
+	if last := readBackupState(config); last != nil {
+		return last.CreatedAt
+	}
+	if newest := newestBackup(ctx, config, s3); newest != nil {
+		return newest.LastModified
+	}
+	return time.Time{}
+}
+
+func readBackupState(config *c.Config) *backup.BackupMetadata {
+	if config.BackupStateFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(config.BackupStateFile)
+	if err == nil {
+		var metadata backup.BackupMetadata
+		if err = json.Unmarshal(b, &metadata); err == nil {
+			return &metadata
+		}
+		config.Logger.Error("parse backup state file failed", zap.Error(err))
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		config.Logger.Error("read backup state file failed", zap.Error(err))
+	}
 	return nil
 }
@@ -2,9 +2,14 @@ package runner
 
 import (
 	"context"
+	"github.com/randomcoww/etcd-wrapper/pkg/backup"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
 	"github.com/randomcoww/etcd-wrapper/pkg/etcdfork"
 	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -33,7 +38,7 @@ func TestSnapshotBackup(t *testing.T) {
 
 	// -- test running backup -- //
 
-	backupConfigs, err := mockBackupConfigs(dataPath)
+	backupConfigs, err := mockSidecarConfigs(dataPath)
 	assert.NoError(t, err)
 
 	// call backup from each member
@@ -42,3 +47,104 @@ func TestSnapshotBackup(t *testing.T) {
 		assert.NoError(t, err)
 	}
 }
+
+func TestSnapshotBackupUnchanged(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s3 := &mockS3Uploads{}
+
+	configs, err := mockRunConfigs(dataPath)
+	assert.NoError(t, err)
+
+	// start etcd cluster to back up
+	for _, config := range configs {
+		p := &etcdfork.EtcdFork{Ctx: ctx}
+		defer p.Wait()
+		defer p.Stop()
+
+		err := RunEtcd(ctx, config, p, s3)
+		assert.NoError(t, err)
+		time.Sleep(config.InitialClusterTimeout + 2*time.Second)
+	}
+
+	backupConfigs, err := mockSidecarConfigs(dataPath)
+	assert.NoError(t, err)
+
+	// any member may be leader so state is shared
+	for _, config := range backupConfigs {
+		config.BackupMaxInterval = 1 * time.Hour
+		config.BackupStateFile = filepath.Join(dataPath, "backup-state.json")
+	}
+	runBackups := func() {
+		for _, config := range backupConfigs {
+			err := RunBackup(ctx, config, s3)
+			assert.NoError(t, err)
+		}
+	}
+
+	// -- test only leader backs up -- //
+
+	runBackups()
+	assert.Equal(t, 1, len(s3.uploads))
+
+	// -- test skipping backup with unchanged revision -- //
+
+	runBackups()
+	assert.Equal(t, 1, len(s3.uploads))
+
+	err = verifyTestPut(ctx, configs[0], "test-key", "test-value")
+	assert.NoError(t, err)
+
+	runBackups()
+	assert.Equal(t, 2, len(s3.uploads))
+}
+
+func TestUnchangedSinceBackup(t *testing.T) {
+	config := &c.Config{
+		BackupMaxInterval: 1 * time.Hour,
+	}
+	status := &etcdclient.StatusResponse{
+		StatusResponse: &etcdserverpb.StatusResponse{
+			Header: &etcdserverpb.ResponseHeader{
+				ClusterId: 1,
+				Revision:  10,
+			},
+		},
+	}
+
+	tests := []struct {
+		name string
+		last *backup.BackupMetadata
+		want bool
+	}{
+		{
+			name: "no backup",
+		},
+		{
+			name: "unchanged",
+			last: &backup.BackupMetadata{ClusterID: 1, Revision: 10, CreatedAt: time.Now().Add(-10 * time.Minute)},
+			want: true,
+		},
+		{
+			name: "revision changed",
+			last: &backup.BackupMetadata{ClusterID: 1, Revision: 9, CreatedAt: time.Now().Add(-10 * time.Minute)},
+		},
+		{
+			name: "other cluster",
+			last: &backup.BackupMetadata{ClusterID: 2, Revision: 10, CreatedAt: time.Now().Add(-10 * time.Minute)},
+		},
+		{
+			name: "max interval passed",
+			last: &backup.BackupMetadata{ClusterID: 1, Revision: 10, CreatedAt: time.Now().Add(-2 * time.Hour)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, unchangedSinceBackup(config, status, tt.last))
+		})
+	}
+}
@@ -0,0 +1,715 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/randomcoww/etcd-wrapper/pkg/backup"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/datadir"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdversion"
+	"github.com/randomcoww/etcd-wrapper/pkg/metrics"
+	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
+	"go.uber.org/zap"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type State string
+
+const (
+	StateDiscover    State = "Discover"
+	StateCheckQuorum State = "CheckQuorum"
+	StateRestore     State = "Restore"
+	StateRecover     State = "Recover"
+	StateReconcile   State = "Reconcile"
+	StateStart       State = "Start"
+	StateDone        State = "Done"
+)
+
+type Reason string
+
+const (
+	ReasonMembersFound      Reason = "members-found"
+	ReasonNoMembers         Reason = "no-members"
+	ReasonQuorum            Reason = "quorum"
+	ReasonNoQuorum          Reason = "no-quorum"
+	ReasonBackupFound       Reason = "backup-found"
+	ReasonNoBackup          Reason = "no-backup"
+	ReasonRestored          Reason = "restored"
+	ReasonForceRecover      Reason = "force-recover"
+	ReasonExistingDataValid Reason = "existing-data-valid"
+	ReasonMembersChanged    Reason = "members-changed"
+	ReasonNoMemberChange    Reason = "no-member-change"
+	ReasonStarted           Reason = "started"
+	ReasonDryRun            Reason = "dry-run"
+	ReasonError             Reason = "error"
+)
+
+type Transition struct {
+	From   State     `json:"from"`
+	To     State     `json:"to"`
+	Reason Reason    `json:"reason"`
+	Time   time.Time `json:"time"`
+}
+
+// DecisionRecord is persisted on every run so that the bootstrap path of each member can be audited
+type DecisionRecord struct {
+	Time          time.Time    `json:"time"`
+	Name          string       `json:"name"`
+	Decision      Decision     `json:"decision"`
+	Path          []Transition `json:"path"`
+	ClusterID     uint64       `json:"clusterID,omitempty"`
+	MemberIDs     []uint64     `json:"memberIDs,omitempty"`
+	LocalMemberID uint64       `json:"localMemberID,omitempty"`
+	SnapshotKey   string       `json:"snapshotKey,omitempty"`
+	Error         string       `json:"error,omitempty"`
+}
+
+const (
+	statusKeySuffix string = "status/"
+)
+
+type bootstrap struct {
+	config      *c.Config
+	s3          s3client.Client
+	etcdRunner  etcdProcess // nil runs without taking action
+	client      etcdclient.EtcdClient
+	plan        *Plan
+	snapshotKey string
+	dataCleared bool
+}
+
+func (b *bootstrap) close() {
+	if b.client != nil {
+		b.client.Close()
+	}
+}
+
+func (b *bootstrap) dryRun() bool {
+	return b.etcdRunner == nil
+}
+
+func (b *bootstrap) run(ctx context.Context) error {
+	state := StateDiscover
+	for state != StateDone {
+		next, reason, err := b.step(ctx, state)
+		if err != nil {
+			b.transition(state, StateDone, ReasonError)
+			b.config.Health.SetBootstrap(string(StateDone), string(b.plan.Decision), string(ReasonError), false, err)
+			b.persist(ctx, err)
+			return err
+		}
+		b.transition(state, next, reason)
+		// etcd exec does not return so record is written before start
+		if next == StateStart {
+			b.persist(ctx, nil)
+		}
+		state = next
+	}
+	return nil
+}
+
+func (b *bootstrap) step(ctx context.Context, state State) (State, Reason, error) {
+	switch state {
+	case StateDiscover:
+		return b.discover(ctx)
+	case StateCheckQuorum:
+		return b.checkQuorum(ctx)
+	case StateRestore:
+		return b.restore(ctx)
+	case StateRecover:
+		return b.recover(ctx)
+	case StateReconcile:
+		return b.reconcile(ctx)
+	case StateStart:
+		return b.start(ctx)
+	}
+	return StateDone, ReasonError, fmt.Errorf("unknown bootstrap state %s", state)
+}
+
+func (b *bootstrap) transition(from, to State, reason Reason) {
+	b.config.Logger.Info("bootstrap transition", zap.String("from", string(from)), zap.String("to", string(to)), zap.String("reason", string(reason)))
+	b.plan.Path = append(b.plan.Path, Transition{
+		From:   from,
+		To:     to,
+		Reason: reason,
+		Time:   time.Now(),
+	})
+	b.config.Health.SetBootstrap(string(to), string(b.plan.Decision), string(reason), to == StateDone && reason != ReasonError, nil)
+}
+
+func (b *bootstrap) discover(ctx context.Context) (State, Reason, error) {
+	if err := b.checkBinaryVersion(ctx); err != nil {
+		return StateDone, ReasonError, err
+	}
+	if b.config.WarmRejoin {
+		b.plan.ExistingData = readExistingIdentity(b.config)
+	}
+
+	// wait for existing cluster
+	clusterCtx, clusterCancel := context.WithTimeout(ctx, time.Duration(b.config.InitialClusterTimeout))
+	defer clusterCancel()
+
+	client, err := etcdclient.NewClientFromPeers(clusterCtx, b.config)
+	if err == nil {
+		b.client = client
+		b.plan.MembersFound = true
+		b.config.Logger.Info("existing members found")
+		return StateCheckQuorum, ReasonMembersFound, nil
+	}
+
+	// no members found
+	b.config.Logger.Info("no members found")
+	// local member may be the only one left of the lost cluster
+	if b.config.RecoverClusterID != 0 && b.config.RecoverSource != "s3" {
+		if identity := readExistingIdentity(b.config); identity != nil {
+			b.plan.ExistingData = identity
+			return StateRecover, ReasonForceRecover, nil
+		}
+	}
+	return b.noMembers(ctx)
+}
+
+// noMembers moves to restore from backup or given source
+func (b *bootstrap) noMembers(ctx context.Context) (State, Reason, error) {
+	// data restore always starts from an empty data dir
+	b.plan.ClearData = true
+
+	// backup bucket is not used when restoring from a given source
+	if b.config.RestoreFrom != "" {
+		return StateRestore, ReasonNoMembers, nil
+	}
+	verifyS3Ctx, verifyS3Cancel := context.WithTimeout(ctx, b.config.S3VerifyTimeout)
+	defer verifyS3Cancel()
+	// if backup bucket can't be verified, fail instead of moving to new cluster
+	if err := b.s3.Verify(verifyS3Ctx, b.config); err != nil {
+		b.config.Logger.Error("failed to verify backup S3 resource", zap.Error(err))
+		return StateDone, ReasonError, err
+	}
+	return StateRestore, ReasonNoMembers, nil
+}
+
+func (b *bootstrap) checkQuorum(ctx context.Context) (State, Reason, error) {
+	clusterCtx, clusterCancel := context.WithTimeout(ctx, time.Duration(b.config.InitialClusterTimeout))
+	defer clusterCancel()
+
+	if err := b.client.GetQuorum(clusterCtx); err != nil {
+		b.config.Logger.Info("no quorum found")
+		// existing data, if kept, is needed to recover quorum
+		b.plan.ClearData = b.plan.ExistingData == nil
+		b.plan.Decision = DecisionStartNoQuorum
+		b.plan.Reason = "members found without quorum"
+		if b.config.RecoverClusterID != 0 {
+			return StateRecover, ReasonForceRecover, nil
+		}
+		return StateStart, ReasonNoQuorum, nil
+	}
+	b.plan.Quorum = true
+	b.config.Logger.Info("quorum found")
+	if b.config.RecoverClusterID != 0 {
+		b.config.Logger.Warn("ignoring force recover since cluster has quorum")
+	}
+	return StateReconcile, ReasonQuorum, nil
+}
+
+func (b *bootstrap) restore(ctx context.Context) (State, Reason, error) {
+	if b.config.RestoreFrom != "" {
+		return b.restoreFrom(ctx)
+	}
+	var err error
+	b.plan.BackupKey, b.plan.BackupKeyProvisional, err = planBackupKey(ctx, b.config, b.s3)
+	if err != nil {
+		return StateDone, ReasonError, err
+	}
+	b.plan.Decision = DecisionRestore
+	b.plan.Reason = "no members found and backup available"
+	if b.plan.BackupKey == "" {
+		b.plan.Decision = DecisionStartNew
+		b.plan.Reason = "no members found and no backup available"
+	}
+	if b.dryRun() {
+		if b.plan.BackupKey == "" {
+			return StateStart, ReasonNoBackup, nil
+		}
+		return StateStart, ReasonBackupFound, nil
+	}
+
+	if err := b.clearData(); err != nil {
+		return StateDone, ReasonError, err
+	}
+	// attempt restoring backup
+	if b.config.RestoreBarrierTimeout > 0 {
+		b.snapshotKey, err = backup.RestoreSnapshotWithBarrier(ctx, b.config, b.s3, restoreVersionBump)
+	} else {
+		b.snapshotKey, err = backup.RestoreSnapshot(ctx, b.config, b.s3, restoreVersionBump)
+	}
+	if err != nil {
+		return StateDone, ReasonError, err
+	}
+	// backup resource accessible but no backups found. move on to new cluster from scratch
+	if b.snapshotKey == "" {
+		b.plan.Decision = DecisionStartNew
+		return StateStart, ReasonNoBackup, nil
+	}
+	b.plan.Decision = DecisionRestore
+	return StateStart, ReasonRestored, nil
+}
+
+func (b *bootstrap) restoreFrom(ctx context.Context) (State, Reason, error) {
+	b.plan.BackupKey = b.config.RestoreFrom
+	b.plan.Decision = DecisionRestore
+	b.plan.Reason = "no members found and restore source given"
+	if b.dryRun() {
+		return StateStart, ReasonBackupFound, nil
+	}
+
+	if err := b.clearData(); err != nil {
+		return StateDone, ReasonError, err
+	}
+	if err := backup.RestoreSnapshotFrom(ctx, b.config, b.config.RestoreFrom, restoreVersionBump); err != nil {
+		return StateDone, ReasonError, err
+	}
+	b.snapshotKey = b.config.RestoreFrom
+	return StateStart, ReasonRestored, nil
+}
+
+// recover starts a new single member cluster from data of the lost cluster
+// Cluster ID of the lost cluster must be given so that this cannot run on a cluster it was not meant for
+// Recovered cluster gets a new cluster ID. Recovered data records the lost cluster ID so that if the flag is left set, later restarts take the path they would without it
+func (b *bootstrap) recover(ctx context.Context) (State, Reason, error) {
+	clusterID, err := b.lostClusterID(ctx)
+	if err != nil {
+		return StateDone, ReasonError, err
+	}
+	if clusterID != b.config.RecoverClusterID {
+		if datadir.ReadRecoveredFrom(b.config.Env["ETCD_DATA_DIR"]) != b.config.RecoverClusterID {
+			return StateDone, ReasonError, fmt.Errorf("force recover cluster ID %x does not match cluster ID %x", b.config.RecoverClusterID, clusterID)
+		}
+		b.config.Logger.Warn("ignoring force recover since local data was already recovered from cluster", zap.String("clusterID", fmt.Sprintf("%x", b.config.RecoverClusterID)))
+		if !b.plan.MembersFound {
+			return b.noMembers(ctx)
+		}
+		return StateStart, ReasonNoQuorum, nil
+	}
+	b.plan.ClusterID = clusterID
+	b.plan.ClearData = true
+	b.plan.Decision = DecisionForceNewCluster
+	b.plan.Reason = "members found without quorum and force recover requested"
+	if !b.plan.MembersFound {
+		b.plan.Reason = "no members found and force recover requested"
+	}
+	if b.config.RecoverSource == "s3" {
+		b.plan.BackupKey, b.plan.BackupKeyProvisional, err = planBackupKey(ctx, b.config, b.s3)
+		if err != nil {
+			return StateDone, ReasonError, err
+		}
+		if b.plan.BackupKey == "" {
+			return StateDone, ReasonError, fmt.Errorf("force recover: no backup found")
+		}
+	}
+	b.config = singleMemberConfig(b.config)
+	if b.dryRun() {
+		return StateStart, ReasonBackupFound, nil
+	}
+
+	b.config.Logger.Warn("force recovering as single member cluster", zap.String("clusterID", fmt.Sprintf("%x", clusterID)), zap.String("source", b.config.RecoverSource))
+	if b.config.RecoverSource == "s3" {
+		if err := b.clearData(); err != nil {
+			return StateDone, ReasonError, err
+		}
+		b.snapshotKey, err = backup.RestoreSnapshot(ctx, b.config, b.s3, restoreVersionBump)
+		if err != nil {
+			return StateDone, ReasonError, err
+		}
+		if b.snapshotKey == "" {
+			return StateDone, ReasonError, fmt.Errorf("force recover: no backup found")
+		}
+	} else {
+		if err := backup.RestoreDataDirSnapshot(ctx, b.config, restoreVersionBump); err != nil {
+			return StateDone, ReasonError, err
+		}
+		b.dataCleared = true
+		b.snapshotKey = b.config.Env["ETCD_DATA_DIR"]
+	}
+	if err := datadir.WriteRecoveredFrom(b.config.Env["ETCD_DATA_DIR"], clusterID); err != nil {
+		b.config.Logger.Error("record recovered cluster ID failed", zap.Error(err))
+		return StateDone, ReasonError, err
+	}
+	return StateStart, ReasonRestored, nil
+}
+
+// lostClusterID returns cluster ID from local data or from surviving members if recovering from S3
+func (b *bootstrap) lostClusterID(ctx context.Context) (uint64, error) {
+	if b.config.RecoverSource != "s3" {
+		identity := readExistingIdentity(b.config)
+		if identity == nil {
+			return 0, fmt.Errorf("force recover: no usable local data")
+		}
+		return identity.ClusterID, nil
+	}
+	var err error
+	for _, endpoint := range b.client.C().Endpoints() {
+		statusCtx, statusCancel := context.WithTimeout(ctx, time.Duration(b.config.ClientTimeout))
+		var status etcdclient.Status
+		status, err = b.client.Status(statusCtx, endpoint)
+		statusCancel()
+		if err == nil {
+			return status.GetHeader().GetClusterId(), nil
+		}
+	}
+	return 0, fmt.Errorf("force recover: get cluster ID from members: %w", err)
+}
+
+// joinConfig returns config with initial cluster set to current members as etcdctl member add does
+// Cluster may not have all members in initial cluster such as after force recover
+func joinConfig(config *c.Config, listResp etcdclient.Members, localID uint64) *c.Config {
+	join := *config
+	join.Env = maps.Clone(config.Env)
+	var initialCluster []string
+	for _, member := range listResp.GetMembers() {
+		name := member.GetName()
+		if member.GetID() == localID {
+			name = config.Env["ETCD_NAME"]
+		}
+		for _, peerURL := range member.GetPeerURLs() {
+			initialCluster = append(initialCluster, name+"="+peerURL)
+		}
+	}
+	join.Env["ETCD_INITIAL_CLUSTER"] = strings.Join(initialCluster, ",")
+	return &join
+}
+
+// singleMemberConfig returns config with initial cluster set to only the local member
+func singleMemberConfig(config *c.Config) *c.Config {
+	single := *config
+	single.Env = maps.Clone(config.Env)
+	var initialCluster []string
+	for _, peerURL := range config.InitialAdvertisePeerURLs {
+		initialCluster = append(initialCluster, config.Env["ETCD_NAME"]+"="+peerURL)
+	}
+	single.Env["ETCD_INITIAL_CLUSTER"] = strings.Join(initialCluster, ",")
+	single.ClusterPeerURLs = config.InitialAdvertisePeerURLs
+	return &single
+}
+
+func (b *bootstrap) reconcile(ctx context.Context) (State, Reason, error) {
+	// cluster with quorum found - this is the most common scenario
+	clientCtx, clientCancel := context.WithTimeout(ctx, time.Duration(b.config.ClientTimeout*2))
+	defer clientCancel()
+
+	listResp, err := b.client.MemberList(clientCtx)
+	if err != nil {
+		b.config.Logger.Error("list member failed", zap.Error(err))
+		return StateDone, ReasonError, err
+	}
+	b.plan.ClusterID = listResp.GetHeader().GetClusterId()
+	for _, member := range listResp.GetMembers() {
+		b.plan.Members = append(b.plan.Members, PlanMember{
+			ID:        member.GetID(),
+			Name:      member.GetName(),
+			PeerURLs:  member.GetPeerURLs(),
+			IsLearner: member.GetIsLearner(),
+		})
+	}
+	if err := b.checkClusterVersion(ctx, listResp); err != nil {
+		return StateDone, ReasonError, err
+	}
+	localMember := findLocalMember(listResp, b.config)
+	b.plan.localMember = localMember
+	b.plan.LocalMemberID = localMember.GetID()
+
+	if b.plan.ExistingData != nil {
+		leaderIndex, err := b.leaderRaftIndex(ctx, listResp)
+		if err != nil {
+			b.config.Logger.Error("get leader status failed", zap.Error(err))
+			return StateDone, ReasonError, err
+		}
+		if canRejoin(b.config, b.plan.ExistingData, listResp, localMember, leaderIndex) {
+			b.plan.Decision = DecisionRejoin
+			b.plan.Reason = "existing data matches local member in cluster"
+			return StateStart, ReasonExistingDataValid, nil
+		}
+	}
+	b.plan.ClearData = true
+	b.plan.Decision = DecisionJoin
+	b.plan.Reason = "quorum found"
+
+	// replace my node to join cluster
+	// if my node already exists, it needs to be replaced
+	members := len(listResp.GetMembers())
+	if localMember != nil && members >= len(b.config.ClusterPeerURLs) {
+		b.plan.MemberChanges = append(b.plan.MemberChanges, MemberChange{
+			Action:   MemberActionRemove,
+			ID:       localMember.GetID(),
+			PeerURLs: localMember.GetPeerURLs(),
+		})
+		localMember = nil
+		members--
+	}
+	if localMember == nil && members < len(b.config.ClusterPeerURLs) {
+		action := MemberActionAdd
+		if b.config.JoinAsLearner {
+			action = MemberActionAddLearner
+		}
+		b.plan.MemberChanges = append(b.plan.MemberChanges, MemberChange{
+			Action:   action,
+			PeerURLs: b.config.InitialAdvertisePeerURLs,
+		})
+	}
+	if len(b.plan.MemberChanges) == 0 {
+		return StateStart, ReasonNoMemberChange, nil
+	}
+	if b.dryRun() {
+		return StateStart, ReasonMembersChanged, nil
+	}
+
+	if err := b.clearData(); err != nil {
+		return StateDone, ReasonError, err
+	}
+	for _, change := range b.plan.MemberChanges {
+		var listResp etcdclient.Members
+		switch change.Action {
+		case MemberActionRemove:
+			listResp, err = b.client.MemberRemove(clientCtx, change.ID)
+		case MemberActionAdd:
+			listResp, err = b.client.MemberAdd(clientCtx, change.PeerURLs)
+		case MemberActionAddLearner:
+			listResp, err = b.client.MemberAddAsLearner(clientCtx, change.PeerURLs)
+		}
+		metrics.MemberOperation(b.config, string(change.Action), err)
+		if err != nil {
+			b.config.Logger.Error("member change failed", zap.String("action", string(change.Action)), zap.Error(err))
+			return StateDone, ReasonError, err
+		}
+		b.plan.localMember = findLocalMember(listResp, b.config)
+		b.config.Logger.Info("member change applied", zap.String("action", string(change.Action)), zap.Strings("peerURLs", change.PeerURLs))
+		if change.Action != MemberActionRemove {
+			b.config = joinConfig(b.config, listResp, b.plan.localMember.GetID())
+		}
+	}
+	b.plan.LocalMemberID = b.plan.localMember.GetID()
+	return StateStart, ReasonMembersChanged, nil
+}
+
+// leaderRaftIndex returns raft index of the cluster leader
+func (b *bootstrap) leaderRaftIndex(ctx context.Context, listResp etcdclient.Members) (uint64, error) {
+	status := func(endpoint string) (etcdclient.Status, error) {
+		statusCtx, statusCancel := context.WithTimeout(ctx, time.Duration(b.config.ClientTimeout))
+		defer statusCancel()
+		return b.client.Status(statusCtx, endpoint)
+	}
+
+	var err error
+	for _, endpoint := range b.client.C().Endpoints() {
+		var s etcdclient.Status
+		s, err = status(endpoint)
+		if err != nil {
+			continue
+		}
+		if s.GetHeader().GetMemberId() == s.GetLeader() {
+			return s.GetRaftIndex(), nil
+		}
+		for _, member := range listResp.GetMembers() {
+			if member.GetID() == s.GetLeader() && len(member.GetClientURLs()) > 0 {
+				if s, err = status(member.GetClientURLs()[0]); err != nil {
+					return 0, err
+				}
+				return s.GetRaftIndex(), nil
+			}
+		}
+	}
+	if err == nil {
+		err = fmt.Errorf("leader not found")
+	}
+	return 0, err
+}
+
+// checkBinaryVersion reads version of local etcd and etcdutl binaries
+// Snapshots and clusters are checked against the etcd version
+func (b *bootstrap) checkBinaryVersion(ctx context.Context) error {
+	if b.config.VersionCheck == etcdversion.CheckOff {
+		return nil
+	}
+	versionCtx, versionCancel := context.WithTimeout(ctx, time.Duration(b.config.ClientTimeout))
+	defer versionCancel()
+
+	etcdVersion, err := etcdversion.Binary(versionCtx, b.config.EtcdBinaryFile, "--version")
+	if err != nil {
+		b.config.Logger.Error("get etcd version failed", zap.Error(err))
+		return err
+	}
+	etcdutlVersion, err := etcdversion.Binary(versionCtx, b.config.EtcdutlBinaryFile, "version")
+	if err != nil {
+		b.config.Logger.Error("get etcdutl version failed", zap.Error(err))
+		return err
+	}
+	b.config.Logger.Info("etcd version", zap.String("etcd", etcdVersion.String()), zap.String("etcdutl", etcdutlVersion.String()))
+	// etcdutl restores data for etcd and should be of the same minor version
+	if etcdutlVersion.Major != etcdVersion.Major || etcdutlVersion.Minor != etcdVersion.Minor {
+		b.config.Logger.Warn("etcdutl version does not match etcd", zap.String("etcd", etcdVersion.String()), zap.String("etcdutl", etcdutlVersion.String()))
+	}
+	b.config.EtcdVersion = etcdVersion
+	b.plan.EtcdVersion = etcdVersion.String()
+	return nil
+}
+
+// checkClusterVersion checks local etcd version against cluster version before joining
+// Cluster version is read from peer /version and falls back to lowest member version from status
+func (b *bootstrap) checkClusterVersion(ctx context.Context, listResp etcdclient.Members) error {
+	if b.config.EtcdVersion == (etcdversion.Version{}) {
+		return nil
+	}
+	var peerURLs []string
+	for _, member := range listResp.GetMembers() {
+		peerURLs = append(peerURLs, member.GetPeerURLs()...)
+	}
+	versionCtx, versionCancel := context.WithTimeout(ctx, time.Duration(b.config.ClientTimeout))
+	defer versionCancel()
+
+	clusterVersion, err := etcdversion.Cluster(versionCtx, b.config.PeerTLSConfig, peerURLs)
+	if err != nil {
+		b.config.Logger.Warn("get cluster version from peers failed", zap.Error(err))
+		clusterVersion, err = b.lowestMemberVersion(versionCtx)
+		if err != nil {
+			b.config.Logger.Warn("skipping cluster version check", zap.Error(err))
+			return nil
+		}
+	}
+	b.plan.ClusterVersion = clusterVersion.String()
+	return etcdversion.Check(b.config.Logger, b.config.VersionCheck, "cluster", b.config.EtcdVersion, clusterVersion)
+}
+
+func (b *bootstrap) lowestMemberVersion(ctx context.Context) (etcdversion.Version, error) {
+	var lowest *etcdversion.Version
+	for _, endpoint := range b.client.C().Endpoints() {
+		status, err := b.client.Status(ctx, endpoint)
+		if err != nil {
+			continue
+		}
+		v, err := etcdversion.Parse(status.GetVersion())
+		if err != nil {
+			continue
+		}
+		b.config.Logger.Info("member version", zap.String("endpoint", endpoint), zap.String("version", v.String()))
+		if lowest == nil || v.Major < lowest.Major || (v.Major == lowest.Major && v.Minor < lowest.Minor) {
+			lowest = &v
+		}
+	}
+	if lowest == nil {
+		return etcdversion.Version{}, fmt.Errorf("no member version found")
+	}
+	return *lowest, nil
+}
+
+func (b *bootstrap) start(ctx context.Context) (State, Reason, error) {
+	if b.dryRun() {
+		return StateDone, ReasonDryRun, nil
+	}
+	// data can be recreated from cluster
+	if b.plan.ClearData {
+		if err := b.clearData(); err != nil {
+			return StateDone, ReasonError, err
+		}
+	}
+
+	var err error
+	switch b.plan.Decision {
+	case DecisionStartNew:
+		b.config.Logger.Info("starting member new fresh")
+		err = b.etcdRunner.StartNew(b.config)
+	case DecisionForceNewCluster:
+		b.config.Logger.Info("starting member new as single member cluster")
+		err = b.etcdRunner.StartNew(b.config)
+	case DecisionRestore:
+		b.config.Logger.Info("starting member existing with backup data")
+		err = b.etcdRunner.StartExisting(b.config)
+	case DecisionStartNoQuorum:
+		b.config.Logger.Info("starting member existing", zap.Bool("existingData", !b.plan.ClearData))
+		err = b.etcdRunner.StartExisting(b.config)
+	default:
+		b.config.Logger.Info("starting member existing")
+		err = startExisting(ctx, b.config, b.etcdRunner, b.plan.localMember)
+	}
+	if err != nil {
+		return StateDone, ReasonError, err
+	}
+	return StateDone, ReasonStarted, nil
+}
+
+// clearData removes data dir once per run so that restored data is kept
+func (b *bootstrap) clearData() error {
+	if b.dataCleared {
+		return nil
+	}
+	if err := clearExistingData(b.config); err != nil {
+		return err
+	}
+	b.dataCleared = true
+	return nil
+}
+
+func (b *bootstrap) record(err error) *DecisionRecord {
+	record := &DecisionRecord{
+		Time:          time.Now(),
+		Name:          b.config.Env["ETCD_NAME"],
+		Decision:      b.plan.Decision,
+		Path:          b.plan.Path,
+		ClusterID:     b.plan.ClusterID,
+		LocalMemberID: b.plan.LocalMemberID,
+		SnapshotKey:   b.snapshotKey,
+	}
+	for _, member := range b.plan.Members {
+		record.MemberIDs = append(record.MemberIDs, member.ID)
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	return record
+}
+
+// persist writes decision record. Failures are logged and do not stop bootstrap
+func (b *bootstrap) persist(ctx context.Context, err error) {
+	if b.dryRun() {
+		return
+	}
+	data, jsonErr := json.Marshal(b.record(err))
+	if jsonErr != nil {
+		b.config.Logger.Error("encode decision record failed", zap.Error(jsonErr))
+		return
+	}
+	if b.config.StatusFile != "" {
+		if err := writeFileAtomic(b.config.StatusFile, data); err != nil {
+			b.config.Logger.Error("write decision record failed", zap.String("path", b.config.StatusFile), zap.Error(err))
+		}
+	}
+	if b.config.StatusS3 {
+		uploadCtx, uploadCancel := context.WithTimeout(ctx, b.config.S3VerifyTimeout)
+		defer uploadCancel()
+
+		key := backup.InternalKey(b.config, statusKeySuffix+b.config.Env["ETCD_NAME"]+".json")
+		if err := b.s3.Write(uploadCtx, b.config, key, data); err != nil {
+			b.config.Logger.Error("upload decision record failed", zap.String("key", key), zap.Error(err))
+		}
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
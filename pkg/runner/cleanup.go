@@ -0,0 +1,125 @@
+package runner
+
+import (
+	"context"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
+	"github.com/randomcoww/etcd-wrapper/pkg/metrics"
+	"github.com/randomcoww/etcd-wrapper/pkg/util"
+	etcdserverpb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.uber.org/zap"
+	"time"
+)
+
+// MemberCleanup tracks how long members outside of the configured cluster have been unreachable
+type MemberCleanup struct {
+	unreachableSince map[uint64]time.Time
+}
+
+func (m *MemberCleanup) Run(ctx context.Context, config *c.Config) error {
+	defer config.Logger.Sync()
+	if m.unreachableSince == nil {
+		m.unreachableSince = make(map[uint64]time.Time)
+	}
+
+	clientCtx, clientCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+	defer clientCancel()
+
+	client, err := etcdclient.NewClientFromPeersWithQuorum(clientCtx, config)
+	if err != nil {
+		config.Logger.Error("get client failed", zap.Error(err))
+		return err
+	}
+	defer client.Close()
+
+	status, err := client.Status(clientCtx, config.LocalClientURL)
+	if err != nil {
+		config.Logger.Error("get local node status failed", zap.Error(err))
+		return err
+	}
+	if status.GetHeader().GetMemberId() != status.GetLeader() {
+		// only leader makes membership changes
+		m.unreachableSince = make(map[uint64]time.Time)
+		return nil
+	}
+
+	listResp, err := client.MemberList(clientCtx)
+	if err != nil {
+		config.Logger.Error("list member failed", zap.Error(err))
+		return err
+	}
+	clusterPeerURLs, err := config.ResolveClusterPeerURLs(clientCtx)
+	if err != nil {
+		config.Logger.Error("resolve initial cluster failed", zap.Error(err))
+		return err
+	}
+
+	var voters, healthyVoters int
+	var candidate *etcdserverpb.Member
+	now := time.Now()
+	seen := make(map[uint64]struct{})
+	for _, member := range listResp.GetMembers() {
+		seen[member.GetID()] = struct{}{}
+		healthy := memberHealthy(ctx, config, client, member)
+		if !member.GetIsLearner() {
+			voters++
+			if healthy {
+				healthyVoters++
+			}
+		}
+		if healthy || util.HasMatchingElement(member.GetPeerURLs(), clusterPeerURLs) {
+			delete(m.unreachableSince, member.GetID())
+			continue
+		}
+
+		since, ok := m.unreachableSince[member.GetID()]
+		if !ok {
+			since = now
+			m.unreachableSince[member.GetID()] = since
+		}
+		config.Logger.Info("member not in initial cluster and unreachable", zap.Uint64("memberID", member.GetID()), zap.Strings("peerURLs", member.GetPeerURLs()), zap.Duration("unreachable", now.Sub(since)))
+		if candidate == nil && now.Sub(since) >= config.StaleMemberTimeout {
+			candidate = member
+		}
+	}
+	for id := range m.unreachableSince {
+		if _, ok := seen[id]; !ok {
+			delete(m.unreachableSince, id)
+		}
+	}
+	if candidate == nil {
+		return nil
+	}
+
+	// removing an unreachable voter leaves healthy voters unchanged but lowers cluster size
+	if !candidate.GetIsLearner() {
+		remaining := voters - 1
+		if remaining < 1 || healthyVoters < remaining/2+1 {
+			config.Logger.Info("skip removing stale member to keep quorum", zap.Uint64("memberID", candidate.GetID()), zap.Int("voters", voters), zap.Int("healthyVoters", healthyVoters))
+			return nil
+		}
+	}
+	removeCtx, removeCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+	defer removeCancel()
+
+	_, err = client.MemberRemove(removeCtx, candidate.GetID())
+	metrics.MemberOperation(config, string(MemberActionRemove), err)
+	if err != nil {
+		config.Logger.Error("remove stale member failed", zap.Uint64("memberID", candidate.GetID()), zap.Error(err))
+		return err
+	}
+	delete(m.unreachableSince, candidate.GetID())
+	config.Logger.Info("removed stale member", zap.Uint64("memberID", candidate.GetID()), zap.Strings("peerURLs", candidate.GetPeerURLs()))
+	return nil
+}
+
+func memberHealthy(ctx context.Context, config *c.Config, client etcdclient.EtcdClient, member *etcdserverpb.Member) bool {
+	if len(member.GetClientURLs()) == 0 {
+		return false
+	}
+	statusCtx, statusCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+	defer statusCancel()
+
+	_, err := client.Status(statusCtx, member.GetClientURLs()[0])
+	return err == nil
+}
@@ -0,0 +1,74 @@
+package runner
+
+import (
+	"context"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdfork"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestMemberCleanup(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s3 := &mockS3NoBackup{}
+
+	configs, err := mockRunConfigs(dataPath)
+	assert.NoError(t, err)
+
+	for _, config := range configs {
+		p := &etcdfork.EtcdFork{Ctx: ctx}
+		defer p.Wait()
+		defer p.Stop()
+
+		err := RunEtcd(ctx, config, p, s3)
+		assert.NoError(t, err)
+		time.Sleep(config.InitialClusterTimeout + 2*time.Second)
+	}
+
+	// --- add member not in initial cluster --- //
+
+	clientCtx, clientCancel := context.WithTimeout(ctx, configs[0].ClientTimeout)
+	defer clientCancel()
+
+	client, err := etcdclient.NewClientFromPeersWithQuorum(clientCtx, configs[0])
+	assert.NoError(t, err)
+	defer client.Close()
+
+	_, err = client.MemberAdd(clientCtx, []string{"https://127.0.0.1:8099"})
+	assert.NoError(t, err)
+
+	// -- remove from leader sidecar --- //
+
+	sidecarConfigs, err := mockSidecarConfigs(dataPath)
+	assert.NoError(t, err)
+
+	var cleanups []*MemberCleanup
+	for _, config := range sidecarConfigs {
+		config.StaleMemberTimeout = 1 * time.Second
+
+		m := &MemberCleanup{}
+		cleanups = append(cleanups, m)
+		err := m.Run(ctx, config)
+		assert.NoError(t, err)
+	}
+
+	time.Sleep(sidecarConfigs[0].StaleMemberTimeout)
+
+	for i, config := range sidecarConfigs {
+		err := cleanups[i].Run(ctx, config)
+		assert.NoError(t, err)
+	}
+
+	listCtx, listCancel := context.WithTimeout(ctx, configs[0].ClientTimeout)
+	defer listCancel()
+
+	listResp, err := client.MemberList(listCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, len(configs), len(listResp.GetMembers()))
+}
@@ -0,0 +1,136 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
+	"github.com/randomcoww/etcd-wrapper/pkg/metrics"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"go.uber.org/zap"
+	"time"
+)
+
+// RunDefragment defragments local member if it is fragmented above DefragThresholdRatio or DefragThresholdBytes
+// A lock held on a lease in etcd allows one member to defragment at a time
+// Leader waits for fragmented followers and moves leadership away before defragment
+func RunDefragment(ctx context.Context, config *c.Config) error {
+	defer config.Logger.Sync()
+
+	clientCtx, clientCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+	defer clientCancel()
+
+	client, err := etcdclient.NewClientFromPeersWithQuorum(clientCtx, config)
+	if err != nil {
+		config.Logger.Error("get client failed", zap.Error(err))
+		return err
+	}
+	defer client.Close()
+
+	status, err := client.Status(clientCtx, config.LocalClientURL)
+	if err != nil {
+		config.Logger.Error("get local node status failed", zap.Error(err))
+		return err
+	}
+	if !fragmented(config, status) {
+		config.Logger.Info("skipping defragment below threshold", zap.Int64("dbSize", status.GetDbSize()), zap.Int64("dbSizeInUse", status.GetDbSizeInUse()))
+		return nil
+	}
+	if status.GetHeader().GetMemberId() == status.GetLeader() {
+		waiting, err := fragmentedFollowers(ctx, config, client, status.GetLeader())
+		if err != nil {
+			config.Logger.Error("list member failed", zap.Error(err))
+			return err
+		}
+		if waiting > 0 {
+			config.Logger.Info("deferring defragment on leader until followers are defragmented", zap.Int("followers", waiting))
+			return nil
+		}
+	}
+
+	session, err := concurrency.NewSession(client.C(), concurrency.WithTTL(int(config.DefragLockTTL.Seconds())), concurrency.WithContext(ctx))
+	if err != nil {
+		config.Logger.Error("create defragment lock session failed", zap.Error(err))
+		return err
+	}
+	defer session.Close()
+
+	lock := concurrency.NewMutex(session, config.DefragLockKey)
+	if err := lock.TryLock(clientCtx); err != nil {
+		if errors.Is(err, concurrency.ErrLocked) {
+			config.Logger.Info("skipping defragment while another member holds the lock")
+			return nil
+		}
+		config.Logger.Error("acquire defragment lock failed", zap.Error(err))
+		return err
+	}
+	defer func() {
+		unlockCtx, unlockCancel := context.WithTimeout(context.Background(), time.Duration(config.ClientTimeout))
+		defer unlockCancel()
+		if err := lock.Unlock(unlockCtx); err != nil {
+			config.Logger.Error("release defragment lock failed", zap.Error(err))
+		}
+	}()
+
+	return withLeadershipMoved(ctx, config, status, "defragment", func() error {
+		defragCtx, defragCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+		defer defragCancel()
+
+		start := time.Now()
+		err := client.Defragment(defragCtx, config.LocalClientURL)
+		if err != nil {
+			config.Logger.Error("run defragment failed", zap.Error(err))
+			metrics.Defragment(config, time.Since(start), 0, err)
+			return err
+		}
+		duration := time.Since(start)
+		var reclaimed int64
+		if after, err := client.Status(defragCtx, config.LocalClientURL); err == nil {
+			reclaimed = status.GetDbSize() - after.GetDbSize()
+		}
+		metrics.Defragment(config, duration, reclaimed, nil)
+		config.Logger.Info("defragment success", zap.Int64("reclaimedBytes", reclaimed))
+		return nil
+	})
+}
+
+// fragmented returns true if free space in the backend is above either threshold
+func fragmented(config *c.Config, status etcdclient.Status) bool {
+	free := status.GetDbSize() - status.GetDbSizeInUse()
+	if free <= 0 {
+		return false
+	}
+	if config.DefragThresholdBytes > 0 && free > config.DefragThresholdBytes {
+		return true
+	}
+	return config.DefragThresholdRatio > 0 && float64(free)/float64(status.GetDbSize()) > config.DefragThresholdRatio
+}
+
+// fragmentedFollowers returns the number of voting followers that are fragmented above threshold
+// Followers not responding are not waited on
+func fragmentedFollowers(ctx context.Context, config *c.Config, client etcdclient.EtcdClient, leaderID uint64) (int, error) {
+	listCtx, listCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+	defer listCancel()
+
+	listResp, err := client.MemberList(listCtx)
+	if err != nil {
+		return 0, err
+	}
+	var count int
+	for _, member := range listResp.GetMembers() {
+		if member.GetID() == leaderID || member.GetIsLearner() || len(member.GetClientURLs()) == 0 {
+			continue
+		}
+		statusCtx, statusCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+		status, err := client.Status(statusCtx, member.GetClientURLs()[0])
+		statusCancel()
+		if err != nil {
+			config.Logger.Info("follower not available for defragment status", zap.Uint64("memberID", member.GetID()), zap.Error(err))
+			continue
+		}
+		if fragmented(config, status) {
+			count++
+		}
+	}
+	return count, nil
+}
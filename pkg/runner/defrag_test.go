@@ -0,0 +1,240 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdfork"
+	"github.com/stretchr/testify/assert"
+	"go.etcd.io/etcd/api/v3/etcdserverpb"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefragment(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s3 := &mockS3NoBackup{}
+
+	configs, err := mockRunConfigs(dataPath)
+	assert.NoError(t, err)
+
+	for _, config := range configs {
+		p := &etcdfork.EtcdFork{Ctx: ctx}
+		defer p.Wait()
+		defer p.Stop()
+
+		err := RunEtcd(ctx, config, p, s3)
+		assert.NoError(t, err)
+		time.Sleep(config.InitialClusterTimeout + 2*time.Second)
+	}
+
+	defragConfigs, err := mockSidecarConfigs(dataPath)
+	assert.NoError(t, err)
+	for _, config := range defragConfigs {
+		config.DefragThresholdRatio = 0.5
+		config.DefragLockKey = "/etcd-wrapper/defrag-lock"
+		config.DefragLockTTL = 10 * time.Second
+	}
+
+	err = verifyTestFragment(ctx, configs[0])
+	assert.NoError(t, err)
+	// followers apply compaction in the background and report size in use on next commit
+	for _, config := range defragConfigs {
+		assert.Eventually(t, func() bool {
+			assert.NoError(t, verifyTestPut(ctx, configs[0], "test-key", "test-value"))
+			return verifyTestFragmented(t, ctx, config)
+		}, 30*time.Second, time.Second)
+	}
+
+	leader, leaderID := verifyTestLeader(t, ctx, configs)
+	var leaderConfig *c.Config
+	var followerConfigs []*c.Config
+	for i, config := range configs {
+		if config == leader {
+			leaderConfig = defragConfigs[i]
+			continue
+		}
+		followerConfigs = append(followerConfigs, defragConfigs[i])
+	}
+
+	// -- lock held by another member -- //
+
+	client, err := etcdclient.NewClient(ctx, configs[0], []string{configs[0].LocalClientURL})
+	assert.NoError(t, err)
+	defer client.Close()
+
+	session, err := concurrency.NewSession(client.C(), concurrency.WithTTL(10))
+	assert.NoError(t, err)
+	lock := concurrency.NewMutex(session, "/etcd-wrapper/defrag-lock")
+	assert.NoError(t, lock.Lock(ctx))
+
+	err = RunDefragment(ctx, followerConfigs[0])
+	assert.NoError(t, err)
+	assert.True(t, verifyTestFragmented(t, ctx, followerConfigs[0]))
+
+	assert.NoError(t, lock.Unlock(ctx))
+	session.Close()
+
+	// -- leader waits for followers -- //
+
+	err = RunDefragment(ctx, leaderConfig)
+	assert.NoError(t, err)
+	assert.True(t, verifyTestFragmented(t, ctx, leaderConfig))
+
+	for _, config := range followerConfigs {
+		err = RunDefragment(ctx, config)
+		assert.NoError(t, err)
+		assert.False(t, verifyTestFragmented(t, ctx, config))
+	}
+
+	// -- leader moves leadership and defragments last -- //
+
+	err = RunDefragment(ctx, leaderConfig)
+	assert.NoError(t, err)
+	assert.False(t, verifyTestFragmented(t, ctx, leaderConfig))
+
+	time.Sleep(2 * time.Second)
+	_, newLeaderID := verifyTestLeader(t, ctx, configs)
+	assert.NotEqual(t, leaderID, newLeaderID)
+}
+
+func TestDefragmentSingleMember(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	configs, err := mockRunConfigs(dataPath)
+	assert.NoError(t, err)
+	config := singleMemberConfig(configs[0])
+
+	p := &etcdfork.EtcdFork{Ctx: ctx}
+	defer p.Wait()
+	defer p.Stop()
+
+	err = RunEtcd(ctx, config, p, &mockS3NoBackup{})
+	assert.NoError(t, err)
+	time.Sleep(config.InitialClusterTimeout + 2*time.Second)
+
+	defragConfigs, err := mockSidecarConfigs(dataPath)
+	assert.NoError(t, err)
+	defragConfig := defragConfigs[0]
+	defragConfig.ClusterPeerURLs = config.ClusterPeerURLs
+	defragConfig.DefragThresholdRatio = 0.5
+	defragConfig.DefragLockKey = "/etcd-wrapper/defrag-lock"
+	defragConfig.DefragLockTTL = 10 * time.Second
+
+	err = verifyTestFragment(ctx, config)
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		assert.NoError(t, verifyTestPut(ctx, config, "test-key", "test-value"))
+		return verifyTestFragmented(t, ctx, defragConfig)
+	}, 30*time.Second, time.Second)
+
+	// -- only voting member defragments as leader -- //
+
+	err = RunDefragment(ctx, defragConfig)
+	assert.NoError(t, err)
+	assert.False(t, verifyTestFragmented(t, ctx, defragConfig))
+}
+
+func TestFragmented(t *testing.T) {
+	status := func(size, inUse int64) etcdclient.Status {
+		return &etcdclient.StatusResponse{
+			StatusResponse: &etcdserverpb.StatusResponse{
+				DbSize:      size,
+				DbSizeInUse: inUse,
+			},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		config *c.Config
+		status etcdclient.Status
+		want   bool
+	}{
+		{
+			name:   "below ratio",
+			config: &c.Config{DefragThresholdRatio: 0.5},
+			status: status(100, 60),
+		},
+		{
+			name:   "above ratio",
+			config: &c.Config{DefragThresholdRatio: 0.5},
+			status: status(100, 40),
+			want:   true,
+		},
+		{
+			name:   "above bytes",
+			config: &c.Config{DefragThresholdRatio: 0.5, DefragThresholdBytes: 10},
+			status: status(100, 80),
+			want:   true,
+		},
+		{
+			name:   "below bytes with ratio disabled",
+			config: &c.Config{DefragThresholdBytes: 50},
+			status: status(100, 60),
+		},
+		{
+			name:   "no free space",
+			config: &c.Config{DefragThresholdBytes: 1},
+			status: status(100, 100),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, fragmented(tt.config, tt.status))
+		})
+	}
+}
+
+// verifyTestFragment writes and removes keys and compacts so that backend is left with free pages
+func verifyTestFragment(ctx context.Context, config *c.Config) error {
+	value := strings.Repeat("0", 64*1024)
+	for i := 0; i < 50; i++ {
+		if err := verifyTestPut(ctx, config, fmt.Sprintf("fragment-%d", i), value); err != nil {
+			return err
+		}
+	}
+
+	clientCtx, clientCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+	defer clientCancel()
+
+	client, err := etcdclient.NewClientFromPeersWithQuorum(clientCtx, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	resp, err := client.C().KV.Delete(clientCtx, "fragment-", clientv3.WithPrefix())
+	if err != nil {
+		return err
+	}
+	_, err = client.C().KV.Compact(clientCtx, resp.Header.Revision, clientv3.WithCompactPhysical())
+	return err
+}
+
+func verifyTestFragmented(t *testing.T, ctx context.Context, config *c.Config) bool {
+	clientCtx, clientCancel := context.WithTimeout(ctx, config.ClientTimeout)
+	defer clientCancel()
+
+	client, err := etcdclient.NewClient(clientCtx, config, []string{config.LocalClientURL})
+	assert.NoError(t, err)
+	defer client.Close()
+
+	status, err := client.Status(clientCtx, config.LocalClientURL)
+	assert.NoError(t, err)
+	return fragmented(config, status)
+}
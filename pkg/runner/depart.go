@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"context"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
+	"github.com/randomcoww/etcd-wrapper/pkg/metrics"
+	"go.uber.org/zap"
+	"time"
+)
+
+// DepartCluster prepares local member to be stopped
+// Leadership is moved off of local member and local member is removed if decommissioning
+func DepartCluster(ctx context.Context, config *c.Config) error {
+	defer config.Logger.Sync()
+
+	clientCtx, clientCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+	defer clientCancel()
+
+	// connect only to local member so that leader requests reach it
+	client, err := etcdclient.NewClient(clientCtx, config, []string{config.LocalClientURL})
+	if err != nil {
+		config.Logger.Error("get local client failed", zap.Error(err))
+		return err
+	}
+	defer client.Close()
+
+	status, err := client.Status(clientCtx, config.LocalClientURL)
+	if err != nil {
+		config.Logger.Error("get local node status failed", zap.Error(err))
+		return err
+	}
+	localID := status.GetHeader().GetMemberId()
+
+	if status.GetLeader() == localID {
+		if err := moveLeadership(ctx, config, localID); err != nil {
+			config.Logger.Error("move leader failed", zap.Error(err))
+			if config.DecommissionOnShutdown {
+				return err
+			}
+		}
+	}
+	if !config.DecommissionOnShutdown {
+		return nil
+	}
+
+	removeCtx, removeCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+	defer removeCancel()
+
+	_, err = client.MemberRemove(removeCtx, localID)
+	metrics.MemberOperation(config, string(MemberActionRemove), err)
+	if err != nil {
+		config.Logger.Error("remove local member failed", zap.Uint64("memberID", localID), zap.Error(err))
+		return err
+	}
+	config.Logger.Info("removed local member", zap.Uint64("memberID", localID))
+	return nil
+}
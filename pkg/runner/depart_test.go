@@ -0,0 +1,92 @@
+package runner
+
+import (
+	"context"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdfork"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDepartCluster(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s3 := &mockS3NoBackup{}
+
+	configs, err := mockRunConfigs(dataPath)
+	assert.NoError(t, err)
+
+	for _, config := range configs {
+		p := &etcdfork.EtcdFork{Ctx: ctx}
+		defer p.Wait()
+		defer p.Stop()
+
+		err := RunEtcd(ctx, config, p, s3)
+		assert.NoError(t, err)
+		time.Sleep(config.InitialClusterTimeout + 2*time.Second)
+	}
+
+	// --- leader moves leadership on restart --- //
+
+	leader, leaderID := verifyTestLeader(t, ctx, configs)
+	err = DepartCluster(ctx, leader)
+	assert.NoError(t, err)
+
+	time.Sleep(2 * time.Second)
+	_, newLeaderID := verifyTestLeader(t, ctx, configs)
+	assert.NotEqual(t, leaderID, newLeaderID)
+
+	// --- member is removed on decommission --- //
+
+	leader, leaderID = verifyTestLeader(t, ctx, configs)
+	leader.DecommissionOnShutdown = true
+	err = DepartCluster(ctx, leader)
+	assert.NoError(t, err)
+
+	var remaining *c.Config
+	for _, config := range configs {
+		if config != leader {
+			remaining = config
+		}
+	}
+	clientCtx, clientCancel := context.WithTimeout(ctx, remaining.ClientTimeout)
+	defer clientCancel()
+
+	client, err := etcdclient.NewClient(clientCtx, remaining, []string{remaining.LocalClientURL})
+	assert.NoError(t, err)
+	defer client.Close()
+
+	listResp, err := client.MemberList(clientCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, len(configs)-1, len(listResp.GetMembers()))
+	for _, member := range listResp.GetMembers() {
+		assert.NotEqual(t, leaderID, member.GetID())
+	}
+}
+
+func verifyTestLeader(t *testing.T, ctx context.Context, configs []*c.Config) (*c.Config, uint64) {
+	for _, config := range configs {
+		clientCtx, clientCancel := context.WithTimeout(ctx, config.ClientTimeout)
+		defer clientCancel()
+
+		client, err := etcdclient.NewClient(clientCtx, config, []string{config.LocalClientURL})
+		assert.NoError(t, err)
+		defer client.Close()
+
+		status, err := client.Status(clientCtx, config.LocalClientURL)
+		if err != nil {
+			continue
+		}
+		if status.GetHeader().GetMemberId() == status.GetLeader() {
+			return config, status.GetLeader()
+		}
+	}
+	t.Fatal("leader not found")
+	return nil, 0
+}
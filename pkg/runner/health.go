@@ -0,0 +1,280 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/randomcoww/etcd-wrapper/pkg/backup"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
+	"github.com/randomcoww/etcd-wrapper/pkg/health"
+	"github.com/randomcoww/etcd-wrapper/pkg/metrics"
+	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// HealthStatus is served on /status
+type HealthStatus struct {
+	Ready        bool                 `json:"ready"`
+	NotReady     []string             `json:"notReady,omitempty"`
+	Bootstrap    *health.Bootstrap    `json:"bootstrap,omitempty"`
+	Member       *MemberStatus        `json:"member,omitempty"`
+	MemberError  string               `json:"memberError,omitempty"`
+	Backup       *health.Backup       `json:"backup,omitempty"`
+	NewestBackup *health.NewestBackup `json:"newestBackup,omitempty"`
+	S3Reachable  bool                 `json:"s3Reachable"`
+	S3Error      string               `json:"s3Error,omitempty"`
+	S3Checked    time.Time            `json:"s3Checked"`
+}
+
+type MemberStatus struct {
+	ID        uint64 `json:"id"`
+	Leader    uint64 `json:"leader"`
+	IsLeader  bool   `json:"isLeader"`
+	IsLearner bool   `json:"isLearner"`
+	Revision  int64  `json:"revision"`
+	RaftIndex uint64 `json:"raftIndex"`
+	DBSize    int64  `json:"dbSize"`
+	Version   string `json:"version"`
+}
+
+// ServeHealth serves /healthz, /readyz, /status and /metrics until ctx is cancelled
+// Run reports ready once bootstrap is done and the local member responds
+// Sidecar reports ready while S3 is reachable and the newest backup is within ReadyBackupMaxAge
+// S3 is checked every HealthS3Interval and probes are served from the last check
+// Run keeps one client to the local member for probes. Sidecar does not check the local member
+func ServeHealth(ctx context.Context, config *c.Config, s3 s3client.Client) error {
+	var member *localMemberClient
+	if config.Cmd == "run" {
+		member = &localMemberClient{ctx: ctx}
+	}
+	refreshS3Health(ctx, config, s3)
+	go func() {
+		ticker := time.NewTicker(config.HealthS3Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				refreshS3Health(ctx, config, s3)
+			}
+		}
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		status := healthStatus(r.Context(), config, member)
+		if !status.Ready {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			for _, reason := range status.NotReady {
+				fmt.Fprintln(w, reason)
+			}
+			return
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		status := healthStatus(r.Context(), config, member)
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		enc.Encode(status)
+	})
+
+	listener, err := net.Listen("tcp", config.HealthListenAddress)
+	if err != nil {
+		config.Logger.Error("health listen failed", zap.Error(err))
+		return err
+	}
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		var err error
+		if config.HealthCertFile != "" {
+			err = server.ServeTLS(listener, config.HealthCertFile, config.HealthKeyFile)
+		} else {
+			err = server.Serve(listener)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			config.Logger.Error("health server failed", zap.Error(err))
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		server.Close()
+		member.close()
+	}()
+	config.Logger.Info("serving health endpoints", zap.String("address", listener.Addr().String()))
+	return nil
+}
+
+func healthStatus(ctx context.Context, config *c.Config, member *localMemberClient) *HealthStatus {
+	status := &HealthStatus{}
+	notReady := func(reason string) {
+		status.NotReady = append(status.NotReady, reason)
+	}
+
+	if member != nil {
+		var err error
+		status.Member, err = member.status(ctx, config)
+		if err != nil {
+			status.MemberError = err.Error()
+		}
+	}
+
+	s3 := config.Health.S3()
+	status.S3Reachable = s3.Reachable
+	status.S3Error = s3.Error
+	status.S3Checked = s3.Time
+
+	switch config.Cmd {
+	case "run":
+		bootstrap := config.Health.Bootstrap()
+		status.Bootstrap = &bootstrap
+		if !bootstrap.Done {
+			notReady(fmt.Sprintf("bootstrap not done: state %s", bootstrap.State))
+		}
+		if status.Member == nil {
+			notReady("local member not responding")
+		}
+
+	case "sidecar":
+		backup := config.Health.Backup()
+		status.Backup = &backup
+		if !status.S3Reachable {
+			notReady("S3 not reachable")
+		}
+		newest := s3.NewestBackup
+		status.NewestBackup = newest
+		if config.ReadyBackupMaxAge > 0 {
+			// new cluster has until max age for its first backup
+			last := config.Health.Started()
+			if newest != nil {
+				last = newest.LastModified
+			}
+			if age := time.Since(last); age > config.ReadyBackupMaxAge {
+				notReady(fmt.Sprintf("newest backup is %s old", age.Round(time.Second)))
+			}
+		}
+	}
+	status.Ready = len(status.NotReady) == 0
+	return status
+}
+
+// localMemberClient keeps one client to the local member across probes. Client is created on first use and reconnects on its own
+type localMemberClient struct {
+	ctx    context.Context
+	mu     sync.Mutex
+	client etcdclient.EtcdClient
+}
+
+func (m *localMemberClient) getClient(config *c.Config) (etcdclient.EtcdClient, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.client != nil {
+		return m.client, nil
+	}
+	if err := m.ctx.Err(); err != nil {
+		return nil, err
+	}
+	client, err := etcdclient.NewClient(m.ctx, config, []string{config.LocalClientURL})
+	if err != nil {
+		return nil, err
+	}
+	m.client = client
+	return client, nil
+}
+
+func (m *localMemberClient) close() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.client != nil {
+		m.client.Close()
+		m.client = nil
+	}
+}
+
+func (m *localMemberClient) status(ctx context.Context, config *c.Config) (*MemberStatus, error) {
+	clientCtx, clientCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+	defer clientCancel()
+
+	client, err := m.getClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err := client.Status(clientCtx, config.LocalClientURL)
+	if err != nil {
+		return nil, err
+	}
+	return &MemberStatus{
+		ID:        status.GetHeader().GetMemberId(),
+		Leader:    status.GetLeader(),
+		IsLeader:  status.GetHeader().GetMemberId() == status.GetLeader(),
+		IsLearner: status.GetIsLearner(),
+		Revision:  status.GetHeader().GetRevision(),
+		RaftIndex: status.GetRaftIndex(),
+		DBSize:    status.GetDbSize(),
+		Version:   status.GetVersion(),
+	}, nil
+}
+
+// refreshS3Health records for health endpoints whether S3 is reachable and, for sidecar, the newest backup
+func refreshS3Health(ctx context.Context, config *c.Config, s3 s3client.Client) {
+	verifyS3Ctx, verifyS3Cancel := context.WithTimeout(ctx, config.S3VerifyTimeout)
+	defer verifyS3Cancel()
+	if err := s3.Verify(verifyS3Ctx, config); err != nil {
+		config.Logger.Error("health S3 check failed", zap.Error(err))
+		config.Health.SetS3(nil, err)
+		return
+	}
+	var newest *health.NewestBackup
+	if config.Cmd == "sidecar" {
+		if object := newestBackup(ctx, config, s3); object != nil {
+			newest = &health.NewestBackup{
+				Key:          object.Key,
+				Size:         object.Size,
+				LastModified: object.LastModified,
+			}
+			if object.Metadata != nil {
+				newest.Revision = object.Metadata.Revision
+			}
+		}
+	}
+	config.Health.SetS3(newest, nil)
+}
+
+func newestBackup(ctx context.Context, config *c.Config, s3 s3client.Client) *backup.Backup {
+	listCtx, listCancel := context.WithTimeout(ctx, config.S3VerifyTimeout)
+	defer listCancel()
+
+	var newest *backup.Backup
+	for _, object := range s3.ListInfo(listCtx, config) {
+		if newest == nil || object.LastModified.After(newest.LastModified) {
+			newest = &backup.Backup{ObjectInfo: object}
+		}
+	}
+	if newest != nil {
+		metadata, err := backup.ReadMetadata(listCtx, config, s3, newest.ObjectInfo)
+		if err != nil {
+			config.Logger.Error("read backup metadata failed", zap.String("key", newest.Key), zap.Error(err))
+		}
+		newest.Metadata = metadata
+	}
+	return newest
+}
@@ -0,0 +1,103 @@
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/randomcoww/etcd-wrapper/pkg/health"
+	"github.com/stretchr/testify/assert"
+	"io"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestServeHealth(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s3 := &mockS3Verifies{}
+
+	// --- sidecar --- //
+
+	sidecarConfigs, err := mockSidecarConfigs(dataPath)
+	assert.NoError(t, err)
+	config := sidecarConfigs[0]
+	config.ClientTimeout = 1 * time.Second
+	config.S3VerifyTimeout = 1 * time.Second
+	config.HealthListenAddress = "127.0.0.1:8070"
+	config.ReadyBackupMaxAge = 1 * time.Minute
+	config.HealthS3Interval = 1 * time.Minute
+	config.Health = health.NewState()
+
+	sidecarCtx, sidecarCancel := context.WithCancel(ctx)
+	err = ServeHealth(sidecarCtx, config, s3)
+	assert.NoError(t, err)
+
+	code, _ := getTestHealth(t, "http://127.0.0.1:8070/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = getTestHealth(t, "http://127.0.0.1:8070/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	code, body := getTestHealth(t, "http://127.0.0.1:8070/metrics")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Contains(t, body, "etcd_wrapper_")
+
+	config.ReadyBackupMaxAge = 1 * time.Nanosecond
+	code, body = getTestHealth(t, "http://127.0.0.1:8070/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "newest backup")
+
+	config.Health.SetBackup("", nil)
+	_, body = getTestHealth(t, "http://127.0.0.1:8070/status")
+	status := &HealthStatus{}
+	assert.NoError(t, json.Unmarshal([]byte(body), status))
+	assert.False(t, status.Ready)
+	assert.True(t, status.S3Reachable)
+	assert.True(t, status.Backup.Skipped)
+	assert.Equal(t, "dummy", status.NewestBackup.Key)
+	// sidecar does not check local member
+	assert.Nil(t, status.Member)
+	assert.Empty(t, status.MemberError)
+
+	// probes are served from the last S3 check
+	assert.Equal(t, int32(1), s3.verifies.Load())
+	sidecarCancel()
+
+	// --- run --- //
+
+	runConfigs, err := mockRunConfigs(dataPath)
+	assert.NoError(t, err)
+	config = runConfigs[0]
+	config.ClientTimeout = 1 * time.Second
+	config.S3VerifyTimeout = 1 * time.Second
+	config.HealthListenAddress = "127.0.0.1:8071"
+	config.HealthS3Interval = 1 * time.Minute
+	config.Health = health.NewState()
+
+	err = ServeHealth(ctx, config, s3)
+	assert.NoError(t, err)
+
+	config.Health.SetBootstrap(string(StateRestore), string(DecisionRestore), string(ReasonNoMembers), false, nil)
+	code, body = getTestHealth(t, "http://127.0.0.1:8071/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "bootstrap not done: state Restore")
+
+	// local member is not running
+	config.Health.SetBootstrap(string(StateDone), string(DecisionRestore), string(ReasonStarted), true, nil)
+	code, body = getTestHealth(t, "http://127.0.0.1:8071/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "local member not responding")
+}
+
+func getTestHealth(t *testing.T, url string) (int, string) {
+	resp, err := http.Get(url)
+	if !assert.NoError(t, err) {
+		return 0, ""
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	return resp.StatusCode, string(body)
+}
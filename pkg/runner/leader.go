@@ -0,0 +1,94 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
+	etcdserverpb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.uber.org/zap"
+	"slices"
+	"time"
+)
+
+var errSingleVoter = errors.New("no voting follower to move leader to")
+
+// withLeadershipMoved runs blocking maintenance on local member
+// If local member is the leader, leadership is moved first and maintenance is skipped if that is not possible
+// Maintenance runs on the leader if it is the only voting member
+func withLeadershipMoved(ctx context.Context, config *c.Config, status etcdclient.Status, name string, op func() error) error {
+	localID := status.GetHeader().GetMemberId()
+	if status.GetLeader() == localID {
+		err := moveLeadership(ctx, config, localID)
+		switch {
+		case errors.Is(err, errSingleVoter):
+			config.Logger.Info("running maintenance on single voting member", zap.String("operation", name))
+		case err != nil:
+			config.Logger.Info("skipping maintenance on leader", zap.String("operation", name), zap.Error(err))
+			return nil
+		}
+	}
+	return op()
+}
+
+// moveLeadership transfers leadership from local member which must be the leader
+func moveLeadership(ctx context.Context, config *c.Config, localID uint64) error {
+	clientCtx, clientCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+	defer clientCancel()
+
+	// connect only to local member so that leader requests reach it
+	client, err := etcdclient.NewClient(clientCtx, config, []string{config.LocalClientURL})
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	listResp, err := client.MemberList(clientCtx)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(listResp.GetMembers(), func(member *etcdserverpb.Member) bool {
+		return member.GetID() != localID && !member.GetIsLearner()
+	}) {
+		return errSingleVoter
+	}
+	transferee := pickTransferee(ctx, config, client, listResp.GetMembers(), localID)
+	if transferee == nil {
+		return fmt.Errorf("no healthy follower to move leader to")
+	}
+	if err := client.MoveLeader(clientCtx, transferee.GetID()); err != nil {
+		return err
+	}
+	config.Logger.Info("moved leader", zap.Uint64("from", localID), zap.Uint64("to", transferee.GetID()))
+	return nil
+}
+
+// pickTransferee returns the voting member other than the leader with the highest raft index
+// Members not responding to status are not considered
+func pickTransferee(ctx context.Context, config *c.Config, client etcdclient.EtcdClient, members []*etcdserverpb.Member, leaderID uint64) *etcdserverpb.Member {
+	var (
+		transferee *etcdserverpb.Member
+		raftIndex  uint64
+	)
+	for _, member := range members {
+		if member.GetID() == leaderID || member.GetIsLearner() || len(member.GetClientURLs()) == 0 {
+			continue
+		}
+		statusCtx, statusCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+		status, err := client.Status(statusCtx, member.GetClientURLs()[0])
+		statusCancel()
+		if err != nil {
+			config.Logger.Info("follower not available for leader transfer", zap.Uint64("memberID", member.GetID()), zap.Error(err))
+			continue
+		}
+		if status.GetIsLearner() {
+			continue
+		}
+		if transferee == nil || status.GetRaftIndex() > raftIndex {
+			transferee = member
+			raftIndex = status.GetRaftIndex()
+		}
+	}
+	return transferee
+}
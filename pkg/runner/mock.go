@@ -2,14 +2,20 @@ package runner
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"github.com/randomcoww/etcd-wrapper/pkg/backup"
 	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdversion"
+	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
 	"github.com/randomcoww/etcd-wrapper/pkg/tlsutil"
 	"go.uber.org/zap"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
@@ -61,9 +67,11 @@ func mockRunConfigs(dataPath string) ([]*c.Config, error) {
 			LocalClientURL:           fmt.Sprintf("https://127.0.0.1:%d", clientPortBase+i),
 			EtcdBinaryFile:           "/etcd/usr/local/bin/etcd",
 			EtcdutlBinaryFile:        "/etcd/usr/local/bin/etcdutl",
+			VersionCheck:             etcdversion.CheckEnforce,
 			ClientTimeout:            8 * time.Second,
 			RestoreTimeout:           2 * time.Second, // local mock
 			InitialClusterTimeout:    2 * time.Second,
+			WarmRejoinMaxLag:         5000,
 			InitialAdvertisePeerURLs: []string{fmt.Sprintf("https://127.0.0.1:%d", peerPortBase+i)},
 		}
 
@@ -143,8 +151,12 @@ func (m *mockS3) Download(ctx context.Context, config *c.Config, key string, han
 	return true, handler(ctx, file)
 }
 
-func (c *mockS3) Upload(ctx context.Context, config *c.Config, key string, reader io.Reader) (int64, error) {
-	return 10, nil
+func (c *mockS3) Upload(ctx context.Context, config *c.Config, key string, reader io.Reader, metadata map[string]string) (int64, string, error) {
+	return 10, "", nil
+}
+
+func (c *mockS3) Write(ctx context.Context, config *c.Config, key string, data []byte) error {
+	return nil
 }
 
 func (c *mockS3) Remove(ctx context.Context, config *c.Config, keys []string) error {
@@ -157,6 +169,70 @@ func (c *mockS3) List(ctx context.Context, config *c.Config) []string {
 	}
 }
 
+func (c *mockS3) ListInfo(ctx context.Context, config *c.Config) []s3client.ObjectInfo {
+	return []s3client.ObjectInfo{
+		{Key: "dummy", Size: mockSnapshotSize(), LastModified: time.Now()},
+	}
+}
+
+func mockSnapshotSize() int64 {
+	info, err := os.Stat(filepath.Join(baseTestPath, "../test-snapshot.db"))
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+func (c *mockS3) Read(ctx context.Context, config *c.Config, key string) ([]byte, string, error) {
+	return nil, "", nil
+}
+
+func (c *mockS3) WriteIfMatch(ctx context.Context, config *c.Config, key string, data []byte, etag string) (bool, error) {
+	return true, nil
+}
+
+// mockS3Metadata lists backups with recorded metadata revisions
+type mockS3Metadata struct {
+	mockS3
+	revisions map[string]int64
+}
+
+func (m *mockS3Metadata) ListInfo(ctx context.Context, config *c.Config) []s3client.ObjectInfo {
+	var objects []s3client.ObjectInfo
+	for key := range m.revisions {
+		objects = append(objects, s3client.ObjectInfo{Key: key, Size: mockSnapshotSize(), LastModified: time.Now()})
+	}
+	slices.SortFunc(objects, func(a, b s3client.ObjectInfo) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return objects
+}
+
+func (m *mockS3Metadata) Read(ctx context.Context, config *c.Config, key string) ([]byte, string, error) {
+	key = strings.TrimSuffix(strings.TrimPrefix(key, s3client.InternalKeyPrefix), ".json")
+	revision, ok := m.revisions[key]
+	if !ok {
+		return nil, "", nil
+	}
+	b, err := json.Marshal(&backup.BackupMetadata{
+		Key:      key,
+		Size:     mockSnapshotSize(),
+		Revision: revision,
+	})
+	return b, "1", err
+}
+
+// mockS3Verifies counts S3 verify calls
+type mockS3Verifies struct {
+	mockS3
+	verifies atomic.Int32
+}
+
+func (m *mockS3Verifies) Verify(ctx context.Context, config *c.Config) error {
+	m.verifies.Add(1)
+	return nil
+}
+
 type mockS3NoBackup struct{}
 
 func (c *mockS3NoBackup) Verify(ctx context.Context, config *c.Config) error {
@@ -167,8 +243,12 @@ func (m *mockS3NoBackup) Download(ctx context.Context, config *c.Config, key str
 	return false, nil
 }
 
-func (c *mockS3NoBackup) Upload(ctx context.Context, config *c.Config, key string, reader io.Reader) (int64, error) {
-	return 10, nil
+func (c *mockS3NoBackup) Upload(ctx context.Context, config *c.Config, key string, reader io.Reader, metadata map[string]string) (int64, string, error) {
+	return 10, "", nil
+}
+
+func (c *mockS3NoBackup) Write(ctx context.Context, config *c.Config, key string, data []byte) error {
+	return nil
 }
 
 func (c *mockS3NoBackup) Remove(ctx context.Context, config *c.Config, keys []string) error {
@@ -178,3 +258,27 @@ func (c *mockS3NoBackup) Remove(ctx context.Context, config *c.Config, keys []st
 func (c *mockS3NoBackup) List(ctx context.Context, config *c.Config) []string {
 	return []string{}
 }
+
+func (c *mockS3NoBackup) ListInfo(ctx context.Context, config *c.Config) []s3client.ObjectInfo {
+	return []s3client.ObjectInfo{}
+}
+
+func (c *mockS3NoBackup) Read(ctx context.Context, config *c.Config, key string) ([]byte, string, error) {
+	return nil, "", nil
+}
+
+func (c *mockS3NoBackup) WriteIfMatch(ctx context.Context, config *c.Config, key string, data []byte, etag string) (bool, error) {
+	return true, nil
+}
+
+// mockS3Uploads records keys of uploaded backups
+type mockS3Uploads struct {
+	mockS3NoBackup
+	uploads []string
+}
+
+func (m *mockS3Uploads) Upload(ctx context.Context, config *c.Config, key string, reader io.Reader, metadata map[string]string) (int64, string, error) {
+	m.uploads = append(m.uploads, key)
+	size, err := io.Copy(io.Discard, reader)
+	return size, "", err
+}
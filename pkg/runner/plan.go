@@ -0,0 +1,170 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"github.com/randomcoww/etcd-wrapper/pkg/backup"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/datadir"
+	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
+	etcdserverpb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"io"
+)
+
+type Decision string
+
+const (
+	DecisionStartNew        Decision = "start-new"
+	DecisionRestore         Decision = "restore"
+	DecisionStartNoQuorum   Decision = "start-existing-no-quorum"
+	DecisionRejoin          Decision = "rejoin-existing-data"
+	DecisionJoin            Decision = "join"
+	DecisionForceNewCluster Decision = "force-new-cluster"
+)
+
+type MemberAction string
+
+const (
+	MemberActionRemove     MemberAction = "remove"
+	MemberActionAdd        MemberAction = "add"
+	MemberActionAddLearner MemberAction = "add-learner"
+)
+
+type MemberChange struct {
+	Action   MemberAction `json:"action"`
+	ID       uint64       `json:"id,omitempty"`
+	PeerURLs []string     `json:"peerURLs"`
+}
+
+type PlanMember struct {
+	ID        uint64   `json:"id"`
+	Name      string   `json:"name"`
+	PeerURLs  []string `json:"peerURLs"`
+	IsLearner bool     `json:"isLearner"`
+}
+
+// Plan is the bootstrap decision for the local member and the inputs that led to it
+type Plan struct {
+	Decision             Decision          `json:"decision"`
+	Reason               string            `json:"reason"`
+	MembersFound         bool              `json:"membersFound"`
+	Quorum               bool              `json:"quorum"`
+	ClusterID            uint64            `json:"clusterID,omitempty"`
+	Members              []PlanMember      `json:"members,omitempty"`
+	LocalMemberID        uint64            `json:"localMemberID,omitempty"`
+	ExistingData         *datadir.Identity `json:"existingData,omitempty"`
+	BackupKey            string            `json:"backupKey,omitempty"`
+	BackupKeyProvisional bool              `json:"backupKeyProvisional,omitempty"`
+	ClearData            bool              `json:"clearData"`
+	MemberChanges        []MemberChange    `json:"memberChanges,omitempty"`
+	EtcdVersion          string            `json:"etcdVersion,omitempty"`
+	ClusterVersion       string            `json:"clusterVersion,omitempty"`
+	Path                 []Transition      `json:"path"`
+
+	localMember *etcdserverpb.Member
+}
+
+// PlanEtcd runs discovery for the local member without taking any action
+func PlanEtcd(ctx context.Context, config *c.Config, s3 s3client.Client) (*Plan, error) {
+	b := &bootstrap{
+		config: config,
+		s3:     s3,
+		plan:   &Plan{},
+	}
+	defer b.close()
+	if err := b.run(ctx); err != nil {
+		return nil, err
+	}
+	return b.plan, nil
+}
+
+// planBackupKey returns the backup key a restore would start from
+// Provisional is true if the key is only known to be usable once the snapshot is downloaded and verified
+// Revision selection is resolved from backup metadata. Metadata revision is a lower bound so the snapshot revision may still be above
+func planBackupKey(ctx context.Context, config *c.Config, s3 s3client.Client) (string, bool, error) {
+	if config.RestoreBarrierTimeout > 0 {
+		manifest, err := backup.CurrentRestoreManifest(ctx, config, s3)
+		if err != nil {
+			return "", false, err
+		}
+		if manifest != nil {
+			return manifest.Key, manifest.Key != "" && !manifest.Verified, nil
+		}
+	}
+	objects, err := backup.RestoreCandidates(ctx, config, s3)
+	if err != nil || len(objects) == 0 {
+		return "", false, err
+	}
+	if config.RestoreRevision == 0 {
+		return objects[0].Key, false, nil
+	}
+	for _, object := range objects {
+		metadata, err := backup.ReadMetadata(ctx, config, s3, object)
+		if err == nil && metadata != nil && metadata.Revision > config.RestoreRevision {
+			continue
+		}
+		return object.Key, true, nil
+	}
+	return "", false, fmt.Errorf("no backup found at or below revision %d", config.RestoreRevision)
+}
+
+func (plan *Plan) WriteText(w io.Writer) error {
+	var lines []string
+	lines = append(lines,
+		fmt.Sprintf("decision: %s", plan.Decision),
+		fmt.Sprintf("reason: %s", plan.Reason),
+		fmt.Sprintf("members found: %t", plan.MembersFound),
+		fmt.Sprintf("quorum: %t", plan.Quorum),
+	)
+	if plan.ClusterID != 0 {
+		lines = append(lines, fmt.Sprintf("cluster ID: %x", plan.ClusterID))
+	}
+	if len(plan.Members) > 0 {
+		lines = append(lines, "members:")
+		for _, member := range plan.Members {
+			lines = append(lines, fmt.Sprintf("  %x name=%s peerURLs=%v learner=%t", member.ID, member.Name, member.PeerURLs, member.IsLearner))
+		}
+	}
+	if plan.LocalMemberID != 0 {
+		lines = append(lines, fmt.Sprintf("local member ID: %x", plan.LocalMemberID))
+	}
+	if plan.ExistingData != nil {
+		lines = append(lines, fmt.Sprintf("existing data: member ID %x cluster ID %x", plan.ExistingData.MemberID, plan.ExistingData.ClusterID))
+	}
+	if plan.BackupKey != "" {
+		if plan.BackupKeyProvisional {
+			lines = append(lines, fmt.Sprintf("backup key: %s (provisional until snapshot is downloaded and verified)", plan.BackupKey))
+		} else {
+			lines = append(lines, fmt.Sprintf("backup key: %s", plan.BackupKey))
+		}
+	}
+	lines = append(lines, fmt.Sprintf("clear data: %t", plan.ClearData))
+	if len(plan.MemberChanges) > 0 {
+		lines = append(lines, "member changes:")
+		for _, change := range plan.MemberChanges {
+			if change.ID != 0 {
+				lines = append(lines, fmt.Sprintf("  %s %x peerURLs=%v", change.Action, change.ID, change.PeerURLs))
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("  %s peerURLs=%v", change.Action, change.PeerURLs))
+		}
+	}
+	if plan.EtcdVersion != "" {
+		lines = append(lines, fmt.Sprintf("etcd version: %s", plan.EtcdVersion))
+	}
+	if plan.ClusterVersion != "" {
+		lines = append(lines, fmt.Sprintf("cluster version: %s", plan.ClusterVersion))
+	}
+	if len(plan.Path) > 0 {
+		lines = append(lines, "path:")
+		for _, t := range plan.Path {
+			lines = append(lines, fmt.Sprintf("  %s -> %s (%s)", t.From, t.To, t.Reason))
+		}
+	}
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package runner
+
+import (
+	"bytes"
+	"context"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdfork"
+	"github.com/stretchr/testify/assert"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPlanEtcd(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	configs, err := mockRunConfigs(dataPath)
+	assert.NoError(t, err)
+
+	// --- no members --- //
+
+	plan, err := PlanEtcd(ctx, configs[0], &mockS3NoBackup{})
+	assert.NoError(t, err)
+	assert.Equal(t, DecisionStartNew, plan.Decision)
+	assert.False(t, plan.MembersFound)
+
+	plan, err = PlanEtcd(ctx, configs[0], &mockS3{})
+	assert.NoError(t, err)
+	assert.Equal(t, DecisionRestore, plan.Decision)
+	assert.Equal(t, "dummy", plan.BackupKey)
+	assert.Equal(t, []State{StateDiscover, StateRestore, StateStart}, transitionStates(plan.Path))
+
+	configs[0].RestoreFrom = "file:///mnt/snapshot.db"
+	plan, err = PlanEtcd(ctx, configs[0], &mockS3NoBackup{})
+	assert.NoError(t, err)
+	assert.Equal(t, DecisionRestore, plan.Decision)
+	assert.Equal(t, "file:///mnt/snapshot.db", plan.BackupKey)
+	configs[0].RestoreFrom = ""
+
+	// --- existing cluster --- //
+
+	for _, config := range configs {
+		p := &etcdfork.EtcdFork{Ctx: ctx}
+		defer p.Wait()
+		defer p.Stop()
+
+		err := RunEtcd(ctx, config, p, &mockS3NoBackup{})
+		assert.NoError(t, err)
+		time.Sleep(config.InitialClusterTimeout + 2*time.Second)
+	}
+
+	plan, err = PlanEtcd(ctx, configs[0], &mockS3NoBackup{})
+	assert.NoError(t, err)
+	assert.Equal(t, DecisionJoin, plan.Decision)
+	assert.True(t, plan.MembersFound)
+	assert.True(t, plan.Quorum)
+	assert.Equal(t, len(configs), len(plan.Members))
+	assert.Equal(t, []MemberChange{
+		{
+			Action:   MemberActionRemove,
+			ID:       plan.LocalMemberID,
+			PeerURLs: configs[0].InitialAdvertisePeerURLs,
+		},
+		{
+			Action:   MemberActionAdd,
+			PeerURLs: configs[0].InitialAdvertisePeerURLs,
+		},
+	}, plan.MemberChanges)
+
+	buf := &bytes.Buffer{}
+	err = plan.WriteText(buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "decision: join")
+	assert.Contains(t, buf.String(), "CheckQuorum -> Reconcile (quorum)")
+
+	// plan takes no action
+	for _, config := range configs {
+		err := verifyTestStatus(ctx, config)
+		assert.NoError(t, err)
+	}
+}
+
+func TestPlanBackupKey(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	configs, err := mockRunConfigs(dataPath)
+	assert.NoError(t, err)
+	config := configs[0]
+
+	ctx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	minioClient := &mockS3Metadata{
+		revisions: map[string]int64{
+			"1": 10,
+			"2": 20,
+			"3": 30,
+		},
+	}
+
+	key, provisional, err := planBackupKey(ctx, config, minioClient)
+	assert.NoError(t, err)
+	assert.Equal(t, "3", key)
+	assert.False(t, provisional)
+
+	// --- revision selection skips backups with metadata revision above --- //
+
+	config.RestoreRevision = 25
+	key, provisional, err = planBackupKey(ctx, config, minioClient)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", key)
+	assert.True(t, provisional)
+
+	config.RestoreRevision = 5
+	_, _, err = planBackupKey(ctx, config, minioClient)
+	assert.Error(t, err)
+
+	// --- plan text marks key as provisional --- //
+
+	buf := &bytes.Buffer{}
+	err = (&Plan{BackupKey: "2", BackupKeyProvisional: true}).WriteText(buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "backup key: 2 (provisional")
+}
@@ -0,0 +1,97 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
+	"github.com/randomcoww/etcd-wrapper/pkg/metrics"
+	"go.uber.org/zap"
+	"time"
+)
+
+const (
+	// match etcd server check for learner readiness
+	learnerReadyPercent uint64 = 90
+)
+
+func PromoteLearner(ctx context.Context, config *c.Config, memberID uint64) error {
+	promoteCtx, promoteCancel := context.WithTimeout(ctx, config.LearnerPromoteTimeout)
+	defer promoteCancel()
+
+	client, err := etcdclient.NewClientFromPeersWithQuorum(promoteCtx, config)
+	if err != nil {
+		config.Logger.Error("get client for learner promote failed", zap.Error(err))
+		return err
+	}
+	defer client.Close()
+
+	for {
+		ok, err := promoteIfCaughtUp(promoteCtx, config, client, memberID)
+		switch {
+		case err != nil:
+			config.Logger.Info("learner promote attempt failed", zap.Error(err))
+		case ok:
+			config.Logger.Info("promoted learner", zap.Uint64("memberID", memberID))
+			return nil
+		}
+
+		timer := time.NewTimer(config.LearnerPromoteInterval)
+		select {
+		case <-promoteCtx.Done():
+			config.Logger.Error("learner promote timed out", zap.Uint64("memberID", memberID))
+			return fmt.Errorf("learner promote timed out: %w", promoteCtx.Err())
+		case <-timer.C:
+			continue
+		}
+	}
+}
+
+func promoteIfCaughtUp(ctx context.Context, config *c.Config, client etcdclient.EtcdClient, memberID uint64) (bool, error) {
+	clientCtx, clientCancel := context.WithTimeout(ctx, config.ClientTimeout)
+	defer clientCancel()
+
+	learnerStatus, err := client.Status(clientCtx, config.LocalClientURL)
+	if err != nil {
+		return false, err
+	}
+	if !learnerStatus.GetIsLearner() {
+		// already promoted
+		return true, nil
+	}
+	leaderEndpoint, err := findLeaderEndpoint(clientCtx, client, learnerStatus.GetLeader())
+	if err != nil {
+		return false, err
+	}
+	leaderStatus, err := client.Status(clientCtx, leaderEndpoint)
+	if err != nil {
+		return false, err
+	}
+
+	config.Logger.Info("learner progress", zap.Uint64("learnerRaftIndex", learnerStatus.GetRaftIndex()), zap.Uint64("leaderRaftIndex", leaderStatus.GetRaftIndex()))
+	if learnerStatus.GetRaftIndex()*100 < leaderStatus.GetRaftIndex()*learnerReadyPercent {
+		return false, nil
+	}
+	_, err = client.MemberPromote(clientCtx, memberID)
+	metrics.MemberOperation(config, "promote", err)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func findLeaderEndpoint(ctx context.Context, client etcdclient.EtcdClient, leaderID uint64) (string, error) {
+	if leaderID == 0 {
+		return "", fmt.Errorf("no leader")
+	}
+	listResp, err := client.MemberList(ctx)
+	if err != nil {
+		return "", err
+	}
+	for _, member := range listResp.GetMembers() {
+		if member.GetID() == leaderID && len(member.GetClientURLs()) > 0 {
+			return member.GetClientURLs()[0], nil
+		}
+	}
+	return "", fmt.Errorf("leader %x not found in member list", leaderID)
+}
@@ -2,15 +2,14 @@ package runner
 
 import (
 	"context"
-	"github.com/randomcoww/etcd-wrapper/pkg/backup"
 	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/datadir"
 	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
 	"github.com/randomcoww/etcd-wrapper/pkg/s3client"
 	"github.com/randomcoww/etcd-wrapper/pkg/util"
 	etcdserverpb "go.etcd.io/etcd/api/v3/etcdserverpb"
 	"go.uber.org/zap"
 	"os"
-	"time"
 )
 
 type etcdProcess interface {
@@ -25,89 +24,25 @@ const (
 )
 
 func RunEtcd(ctx context.Context, config *c.Config, etcdRunner etcdProcess, s3 s3client.Client) error {
-	// always clean out data
-	// data can be recreated from cluster
-	// data restore is needed on full cluster restart
-	if err := clearExistingData(config); err != nil {
-		return err
+	b := &bootstrap{
+		config:     config,
+		s3:         s3,
+		etcdRunner: etcdRunner,
+		plan:       &Plan{},
 	}
+	defer b.close()
+	return b.run(ctx)
+}
 
-	// wait for existing cluster (and quorum)
-	clusterCtx, clusterCancel := context.WithTimeout(ctx, time.Duration(config.InitialClusterTimeout))
-	defer clusterCancel()
-
-	client, err := etcdclient.NewClientFromPeers(clusterCtx, config)
-	if err != nil {
-		// no members found
-		config.Logger.Info("no members found")
-
-		// attempt restoring backup
-		verifyS3Ctx, verifyS3Cancel := context.WithTimeout(ctx, config.S3VerifyTimeout)
-		defer verifyS3Cancel()
-		// if backup bucket can't be verified, fail instead of moving to new cluster
-		if err := s3.Verify(verifyS3Ctx, config); err != nil {
-			config.Logger.Error("failed to verify backup S3 resource", zap.Error(err))
-			return err
-		}
-		ok, err := backup.RestoreSnapshot(ctx, config, s3, restoreVersionBump)
-		if err != nil {
-			return err
-		}
-		// backup resource accessible but no backups found. move on to new cluster from scratch
-		if !ok {
-			config.Logger.Info("starting member new fresh")
-			return etcdRunner.StartNew(config)
-		}
-
-		config.Logger.Info("starting member existing with backup data")
-		return etcdRunner.StartExisting(config)
-	}
-	defer client.Close()
-
-	config.Logger.Info("existing members found")
-	// found members - check if quorum is established
-	if err := client.GetQuorum(clusterCtx); err != nil {
-		config.Logger.Info("no quorum found")
-
-		config.Logger.Info("starting member existing")
-		return etcdRunner.StartExisting(config)
-	}
-
-	config.Logger.Info("quorum found")
-	// cluster with quorum found - this is the most common scenario
-	clientCtx, clientCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout*2))
-	defer clientCancel()
-
-	listResp, err := client.MemberList(clientCtx)
-	if err != nil {
-		config.Logger.Error("list member failed", zap.Error(err))
+func startExisting(ctx context.Context, config *c.Config, etcdRunner etcdProcess, localMember *etcdserverpb.Member) error {
+	if err := etcdRunner.StartExisting(config); err != nil {
 		return err
 	}
-	localMember := findLocalMember(listResp, config)
-
-	// replace my node to join cluster
-	// if my node already exists, it needs to be replaced
-	if localMember != nil && len(listResp.GetMembers()) >= len(config.ClusterPeerURLs) {
-		listResp, err = client.MemberRemove(clientCtx, localMember.GetID())
-		if err != nil {
-			config.Logger.Error("remove member failed", zap.Error(err))
-			return err
-		}
-		localMember = findLocalMember(listResp, config)
-		config.Logger.Info("removed local member")
+	// promote in background while etcd process runs
+	if localMember.GetIsLearner() {
+		go PromoteLearner(ctx, config, localMember.GetID())
 	}
-
-	if localMember == nil && len(listResp.GetMembers()) < len(config.ClusterPeerURLs) {
-		_, err = client.MemberAdd(clientCtx, config.InitialAdvertisePeerURLs)
-		if err != nil {
-			config.Logger.Error("add member failed", zap.Error(err))
-			return err
-		}
-		config.Logger.Info("added local member")
-	}
-
-	config.Logger.Info("starting member existing")
-	return etcdRunner.StartExisting(config)
+	return nil
 }
 
 func clearExistingData(config *c.Config) error {
@@ -120,6 +55,45 @@ func clearExistingData(config *c.Config) error {
 	return nil
 }
 
+func readExistingIdentity(config *c.Config) *datadir.Identity {
+	d, ok := config.Env["ETCD_DATA_DIR"]
+	if !ok || d == "" {
+		return nil
+	}
+	identity, err := datadir.ReadIdentity(config.Logger, d)
+	if err != nil {
+		config.Logger.Info("existing data not usable", zap.Error(err))
+		return nil
+	}
+	config.Logger.Info("found existing data", zap.Uint64("memberID", identity.MemberID), zap.Uint64("clusterID", identity.ClusterID))
+	return identity
+}
+
+// canRejoin returns true if existing data belongs to local member and is recent enough to catch up from the leader
+func canRejoin(config *c.Config, identity *datadir.Identity, listResp etcdclient.Members, localMember *etcdserverpb.Member, leaderIndex uint64) bool {
+	if clusterID := listResp.GetHeader().GetClusterId(); clusterID != identity.ClusterID {
+		config.Logger.Info("existing data belongs to another cluster", zap.Uint64("clusterID", clusterID))
+		return false
+	}
+	if localMember == nil || localMember.GetID() != identity.MemberID {
+		config.Logger.Info("existing data belongs to a removed member")
+		return false
+	}
+	if !util.HasMatchingElement(localMember.GetPeerURLs(), config.InitialAdvertisePeerURLs) {
+		config.Logger.Info("local member peer URLs have changed")
+		return false
+	}
+	if identity.Commit > leaderIndex {
+		config.Logger.Info("existing data is ahead of cluster", zap.Uint64("commit", identity.Commit), zap.Uint64("leaderIndex", leaderIndex))
+		return false
+	}
+	if leaderIndex-identity.Commit > config.WarmRejoinMaxLag {
+		config.Logger.Info("existing data is stale", zap.Uint64("commit", identity.Commit), zap.Uint64("leaderIndex", leaderIndex))
+		return false
+	}
+	return true
+}
+
 func findLocalMember(listResp etcdclient.Members, config *c.Config) *etcdserverpb.Member {
 	for _, member := range listResp.GetMembers() {
 		if member.GetName() == config.Env["ETCD_NAME"] {
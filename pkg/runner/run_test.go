@@ -2,11 +2,17 @@ package runner
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
 	c "github.com/randomcoww/etcd-wrapper/pkg/config"
+	"github.com/randomcoww/etcd-wrapper/pkg/datadir"
 	"github.com/randomcoww/etcd-wrapper/pkg/etcdclient"
 	"github.com/randomcoww/etcd-wrapper/pkg/etcdfork"
 	"github.com/stretchr/testify/assert"
+	etcdserverpb "go.etcd.io/etcd/api/v3/etcdserverpb"
+	"go.uber.org/zap"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -27,6 +33,7 @@ func TestRunNewCluster(t *testing.T) {
 		defer p.Wait()
 		defer p.Stop()
 
+		config.StatusFile = filepath.Join(dataPath, config.Env["ETCD_NAME"]+".json")
 		err := RunEtcd(ctx, config, p, s3)
 		assert.NoError(t, err)
 		time.Sleep(config.InitialClusterTimeout + 2*time.Second)
@@ -37,6 +44,34 @@ func TestRunNewCluster(t *testing.T) {
 		err := verifyTestStatus(ctx, config)
 		assert.NoError(t, err)
 	}
+
+	// verify decision record
+	// members start new until quorum is reached and the rest join
+	for i, config := range configs {
+		b, err := os.ReadFile(config.StatusFile)
+		assert.NoError(t, err)
+		record := &DecisionRecord{}
+		assert.NoError(t, json.Unmarshal(b, record))
+		assert.Equal(t, config.Env["ETCD_NAME"], record.Name)
+		if i < len(configs)-1 {
+			assert.Equal(t, DecisionStartNew, record.Decision)
+			assert.Equal(t, []State{StateDiscover, StateRestore}, transitionStates(record.Path))
+			assert.Equal(t, ReasonNoBackup, record.Path[len(record.Path)-1].Reason)
+			continue
+		}
+		assert.Equal(t, DecisionJoin, record.Decision)
+		assert.Equal(t, []State{StateDiscover, StateCheckQuorum, StateReconcile}, transitionStates(record.Path))
+		assert.NotZero(t, record.LocalMemberID)
+		assert.NotZero(t, record.ClusterID)
+	}
+}
+
+func transitionStates(path []Transition) []State {
+	var states []State
+	for _, t := range path {
+		states = append(states, t.From)
+	}
+	return states
 }
 
 func TestRunExistingCluster(t *testing.T) {
@@ -107,6 +142,210 @@ func TestRunExistingCluster(t *testing.T) {
 	}
 }
 
+func TestRunWarmRejoin(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s3 := &mockS3NoBackup{}
+
+	var ps []*etcdfork.EtcdFork
+	configs, err := mockRunConfigs(dataPath)
+	assert.NoError(t, err)
+
+	for _, config := range configs {
+		config.WarmRejoin = true
+
+		p := &etcdfork.EtcdFork{Ctx: ctx}
+		defer p.Wait()
+		defer p.Stop()
+		ps = append(ps, p)
+
+		err := RunEtcd(ctx, config, p, s3)
+		assert.NoError(t, err)
+		time.Sleep(config.InitialClusterTimeout + 2*time.Second)
+	}
+
+	memberID, err := verifyTestMemberID(ctx, configs[0])
+	assert.NoError(t, err)
+
+	// -- restart one node and keep its member ID --- //
+
+	ps[0].Stop()
+	ps[0].Wait()
+
+	time.Sleep(configs[0].InitialClusterTimeout + 2*time.Second)
+	err = RunEtcd(ctx, configs[0], ps[0], s3)
+	assert.NoError(t, err)
+
+	for _, config := range configs {
+		err := verifyTestStatus(ctx, config)
+		assert.NoError(t, err)
+	}
+
+	rejoinMemberID, err := verifyTestMemberID(ctx, configs[0])
+	assert.NoError(t, err)
+	assert.Equal(t, memberID, rejoinMemberID)
+
+	// -- restart one node after it falls behind and replace it --- //
+
+	ps[0].Stop()
+	ps[0].Wait()
+
+	for i := 0; i < 20; i++ {
+		err = verifyTestPut(ctx, configs[1], fmt.Sprintf("stale-key%d", i), "stale-val")
+		assert.NoError(t, err)
+	}
+	configs[0].WarmRejoinMaxLag = 10
+
+	time.Sleep(configs[0].InitialClusterTimeout + 2*time.Second)
+	err = RunEtcd(ctx, configs[0], ps[0], s3)
+	assert.NoError(t, err)
+
+	for _, config := range configs {
+		err := verifyTestStatus(ctx, config)
+		assert.NoError(t, err)
+	}
+
+	replaceMemberID, err := verifyTestMemberID(ctx, configs[0])
+	assert.NoError(t, err)
+	assert.NotEqual(t, memberID, replaceMemberID)
+
+	val, err := verifyTestData(ctx, configs[0], "stale-key19")
+	assert.NoError(t, err)
+	assert.Equal(t, "stale-val", val)
+}
+
+func TestCanRejoin(t *testing.T) {
+	config := &c.Config{
+		Logger:                   zap.NewNop(),
+		InitialAdvertisePeerURLs: []string{"https://127.0.0.1:8090"},
+		WarmRejoinMaxLag:         100,
+	}
+	localMember := &etcdserverpb.Member{
+		ID:       1,
+		PeerURLs: []string{"https://127.0.0.1:8090"},
+	}
+	listResp := &etcdclient.MemberListResponse{
+		MemberListResponse: &etcdserverpb.MemberListResponse{
+			Header:  &etcdserverpb.ResponseHeader{ClusterId: 10},
+			Members: []*etcdserverpb.Member{localMember},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		identity    *datadir.Identity
+		localMember *etcdserverpb.Member
+		leaderIndex uint64
+		want        bool
+	}{
+		{
+			name:        "caught up",
+			identity:    &datadir.Identity{MemberID: 1, ClusterID: 10, Commit: 1000},
+			localMember: localMember,
+			leaderIndex: 1050,
+			want:        true,
+		},
+		{
+			name:        "other cluster",
+			identity:    &datadir.Identity{MemberID: 1, ClusterID: 11, Commit: 1000},
+			localMember: localMember,
+			leaderIndex: 1000,
+		},
+		{
+			name:        "removed member",
+			identity:    &datadir.Identity{MemberID: 2, ClusterID: 10, Commit: 1000},
+			localMember: localMember,
+			leaderIndex: 1000,
+		},
+		{
+			name:        "stale",
+			identity:    &datadir.Identity{MemberID: 1, ClusterID: 10, Commit: 1000},
+			localMember: localMember,
+			leaderIndex: 1101,
+		},
+		{
+			name:        "ahead of cluster",
+			identity:    &datadir.Identity{MemberID: 1, ClusterID: 10, Commit: 1000},
+			localMember: localMember,
+			leaderIndex: 900,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, canRejoin(config, tt.identity, listResp, tt.localMember, tt.leaderIndex))
+		})
+	}
+}
+
+func TestRunJoinAsLearner(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s3 := &mockS3NoBackup{}
+
+	configs, err := mockRunConfigs(dataPath)
+	assert.NoError(t, err)
+
+	for _, config := range configs {
+		config.JoinAsLearner = true
+		config.LearnerPromoteTimeout = 30 * time.Second
+		config.LearnerPromoteInterval = 1 * time.Second
+
+		p := &etcdfork.EtcdFork{Ctx: ctx}
+		defer p.Wait()
+		defer p.Stop()
+
+		err := RunEtcd(ctx, config, p, s3)
+		assert.NoError(t, err)
+		time.Sleep(config.InitialClusterTimeout + 2*time.Second)
+	}
+
+	// all members should be promoted to voters
+	time.Sleep(configs[0].LearnerPromoteInterval * 4)
+
+	for _, config := range configs {
+		err := verifyTestStatus(ctx, config)
+		assert.NoError(t, err)
+	}
+
+	clientCtx, clientCancel := context.WithTimeout(ctx, configs[0].ClientTimeout)
+	defer clientCancel()
+
+	client, err := etcdclient.NewClientFromPeersWithQuorum(clientCtx, configs[0])
+	assert.NoError(t, err)
+	defer client.Close()
+
+	listResp, err := client.MemberList(clientCtx)
+	assert.NoError(t, err)
+	assert.Equal(t, len(configs), len(listResp.GetMembers()))
+	for _, member := range listResp.GetMembers() {
+		assert.False(t, member.GetIsLearner())
+	}
+}
+
+func verifyTestMemberID(ctx context.Context, config *c.Config) (uint64, error) {
+	clientCtx, clientCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+	defer clientCancel()
+
+	client, err := etcdclient.NewClientFromPeersWithQuorum(clientCtx, config)
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	status, err := client.Status(clientCtx, config.LocalClientURL)
+	if err != nil {
+		return 0, err
+	}
+	return status.GetHeader().GetMemberId(), nil
+}
+
 func verifyTestStatus(ctx context.Context, config *c.Config) error {
 	clientCtx, clientCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
 	defer clientCancel()
@@ -141,3 +380,186 @@ func verifyTestData(ctx context.Context, config *c.Config, key string) (string,
 	}
 	return string(resp.Kvs[0].Value), nil
 }
+
+func TestRunForceRecover(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s3 := &mockS3NoBackup{}
+
+	var ps []*etcdfork.EtcdFork
+	configs, err := mockRunConfigs(dataPath)
+	assert.NoError(t, err)
+
+	for _, config := range configs {
+		p := &etcdfork.EtcdFork{Ctx: ctx}
+		defer p.Wait()
+		defer p.Stop()
+		ps = append(ps, p)
+
+		err := RunEtcd(ctx, config, p, s3)
+		assert.NoError(t, err)
+		time.Sleep(config.InitialClusterTimeout + 2*time.Second)
+	}
+
+	err = verifyTestPut(ctx, configs[0], "test-key", "test-value")
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Second)
+
+	clusterID, err := verifyTestClusterID(ctx, configs[0])
+	assert.NoError(t, err)
+
+	// --- lose quorum with one member left running --- //
+
+	for _, i := range []int{0, 2} {
+		ps[i].Stop()
+		ps[i].Wait()
+	}
+
+	// cluster ID must match
+	configs[0].RecoverClusterID = clusterID + 1
+	err = RunEtcd(ctx, configs[0], ps[0], s3)
+	assert.Error(t, err)
+
+	configs[0].RecoverClusterID = clusterID
+	err = RunEtcd(ctx, configs[0], ps[0], s3)
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Second)
+
+	newClusterID, err := verifyTestClusterID(ctx, configs[0])
+	assert.NoError(t, err)
+	assert.NotEqual(t, clusterID, newClusterID)
+
+	// --- other members rejoin recovered cluster --- //
+
+	ps[1].Stop()
+	ps[1].Wait()
+	for _, i := range []int{1, 2} {
+		err := RunEtcd(ctx, configs[i], ps[i], s3)
+		assert.NoError(t, err)
+		time.Sleep(configs[i].InitialClusterTimeout + 2*time.Second)
+
+		// wait for quorum with new member before adding the next
+		err = verifyTestStatus(ctx, configs[i])
+		assert.NoError(t, err)
+	}
+
+	for _, config := range configs {
+		err := verifyTestStatus(ctx, config)
+		assert.NoError(t, err)
+	}
+	value, err := verifyTestData(ctx, configs[2], "test-key")
+	assert.NoError(t, err)
+	assert.Equal(t, "test-value", value)
+
+	// --- restart without quorum with force recover left set --- //
+
+	for _, i := range []int{0, 2} {
+		ps[i].Stop()
+		ps[i].Wait()
+	}
+	// existing data is kept to restore quorum
+	for _, i := range []int{0, 2} {
+		configs[i].WarmRejoin = true
+		err := RunEtcd(ctx, configs[i], ps[i], s3)
+		assert.NoError(t, err)
+	}
+	time.Sleep(configs[0].InitialClusterTimeout + 2*time.Second)
+
+	for _, config := range configs {
+		err := verifyTestStatus(ctx, config)
+		assert.NoError(t, err)
+	}
+	recoveredClusterID, err := verifyTestClusterID(ctx, configs[0])
+	assert.NoError(t, err)
+	assert.Equal(t, newClusterID, recoveredClusterID)
+}
+
+func TestRunForceRecoverSurvivor(t *testing.T) {
+	dataPath, _ := os.MkdirTemp("", "etcd-test-*")
+	defer os.RemoveAll(dataPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s3 := &mockS3NoBackup{}
+
+	var ps []*etcdfork.EtcdFork
+	configs, err := mockRunConfigs(dataPath)
+	assert.NoError(t, err)
+
+	for _, config := range configs {
+		p := &etcdfork.EtcdFork{Ctx: ctx}
+		defer p.Wait()
+		defer p.Stop()
+		ps = append(ps, p)
+
+		err := RunEtcd(ctx, config, p, s3)
+		assert.NoError(t, err)
+		time.Sleep(config.InitialClusterTimeout + 2*time.Second)
+	}
+
+	err = verifyTestPut(ctx, configs[0], "test-key", "test-value")
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Second)
+
+	clusterID, err := verifyTestClusterID(ctx, configs[0])
+	assert.NoError(t, err)
+
+	// --- local member is the only one left with data --- //
+
+	for _, p := range ps {
+		p.Stop()
+		p.Wait()
+	}
+
+	configs[0].RecoverClusterID = clusterID
+	plan, err := PlanEtcd(ctx, configs[0], s3)
+	assert.NoError(t, err)
+	assert.Equal(t, DecisionForceNewCluster, plan.Decision)
+	assert.False(t, plan.MembersFound)
+
+	err = RunEtcd(ctx, configs[0], ps[0], s3)
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Second)
+
+	newClusterID, err := verifyTestClusterID(ctx, configs[0])
+	assert.NoError(t, err)
+	assert.NotEqual(t, clusterID, newClusterID)
+
+	value, err := verifyTestData(ctx, configs[0], "test-key")
+	assert.NoError(t, err)
+	assert.Equal(t, "test-value", value)
+}
+
+func verifyTestClusterID(ctx context.Context, config *c.Config) (uint64, error) {
+	clientCtx, clientCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+	defer clientCancel()
+
+	client, err := etcdclient.NewClient(clientCtx, config, []string{config.LocalClientURL})
+	if err != nil {
+		return 0, err
+	}
+	defer client.Close()
+
+	status, err := client.Status(clientCtx, config.LocalClientURL)
+	if err != nil {
+		return 0, err
+	}
+	return status.GetHeader().GetClusterId(), nil
+}
+
+func verifyTestPut(ctx context.Context, config *c.Config, key, value string) error {
+	clientCtx, clientCancel := context.WithTimeout(ctx, time.Duration(config.ClientTimeout))
+	defer clientCancel()
+
+	client, err := etcdclient.NewClientFromPeersWithQuorum(clientCtx, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	_, err = client.C().KV.Put(clientCtx, key, value)
+	return err
+}
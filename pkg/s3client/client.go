@@ -3,6 +3,8 @@ package s3client
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"github.com/minio/minio-go/v7"
@@ -12,19 +14,36 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"os"
+	"strings"
 	"time"
 )
 
+const (
+	SHA256MetadataKey string = "Sha256"
+	InternalKeyPrefix string = ".etcd-wrapper/" // wrapper state objects that are never listed as backups
+)
+
 type client struct {
 	*minio.Client
 }
 
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
 type Client interface {
 	Verify(context.Context, *c.Config) error
 	Download(context.Context, *c.Config, string, func(context.Context, io.Reader) error) (bool, error)
-	Upload(context.Context, *c.Config, string, io.Reader) (int64, error)
+	Upload(context.Context, *c.Config, string, io.Reader, map[string]string) (int64, string, error)
+	Write(context.Context, *c.Config, string, []byte) error
 	Remove(context.Context, *c.Config, []string) error
 	List(context.Context, *c.Config) []string
+	ListInfo(context.Context, *c.Config) []ObjectInfo
+	Read(context.Context, *c.Config, string) ([]byte, string, error)
+	WriteIfMatch(context.Context, *c.Config, string, []byte, string) (bool, error)
 }
 
 func NewClient(config *c.Config) (*client, error) {
@@ -79,24 +98,98 @@ func (c *client) Download(ctx context.Context, config *c.Config, key string, han
 	return true, handler(ctx, object)
 }
 
-func (c *client) Upload(ctx context.Context, config *c.Config, key string, reader io.Reader) (int64, error) {
-	buf := &bytes.Buffer{}
-	size, err := io.Copy(buf, reader)
+// Upload spools reader to a temp file so that size is known without holding the snapshot in memory
+// Object is sent as multipart upload of UploadPartSize parts which bounds memory use to a few parts
+// SHA-256 of the object is recorded in user metadata along with metadata passed in and returned with size
+func (c *client) Upload(ctx context.Context, config *c.Config, key string, reader io.Reader, metadata map[string]string) (int64, string, error) {
+	file, err := os.CreateTemp(config.UploadSpoolDir, "etcd-wrapper-upload-*")
+	if err != nil {
+		return 0, "", fmt.Errorf("upload: failed to create spool file: %w", err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), reader)
 	if err != nil {
-		return size, fmt.Errorf("upload: failed to create buffer: %w", err)
+		return size, "", fmt.Errorf("upload: failed to spool: %w", err)
 	}
 	if size == 0 {
-		return size, fmt.Errorf("upload: size is 0")
+		return size, "", fmt.Errorf("upload: size is 0")
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return size, "", fmt.Errorf("upload: failed to rewind spool file: %w", err)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+	userMetadata := map[string]string{
+		SHA256MetadataKey: sum,
 	}
-	if _, err = c.PutObject(ctx, config.S3BackupBucket, key, buf, size, minio.PutObjectOptions{
+	for k, v := range metadata {
+		userMetadata[k] = v
+	}
+	if _, err = c.PutObject(ctx, config.S3BackupBucket, key, file, size, minio.PutObjectOptions{
 		AutoChecksum: minio.ChecksumCRC32,
+		PartSize:     config.UploadPartSize,
+		UserMetadata: userMetadata,
 	}); err != nil {
 		if cleanupErr := c.cleanupIncomplete(config, key); cleanupErr != nil {
-			return size, fmt.Errorf("upload: failed to put object: %w\n  failed to cleanup incomplete upload: %w", err, cleanupErr)
+			return size, "", fmt.Errorf("upload: failed to put object: %w\n  failed to cleanup incomplete upload: %w", err, cleanupErr)
+		}
+		return size, "", fmt.Errorf("upload: failed to put object: %w", err)
+	}
+	return size, sum, nil
+}
+
+// Write puts small object content in a single request
+func (c *client) Write(ctx context.Context, config *c.Config, key string, data []byte) error {
+	if _, err := c.PutObject(ctx, config.S3BackupBucket, key, bytes.NewReader(data), int64(len(data)), minio.PutObjectOptions{
+		AutoChecksum: minio.ChecksumCRC32,
+	}); err != nil {
+		return fmt.Errorf("write: failed to put object: %w", err)
+	}
+	return nil
+}
+
+// Read returns small object content and ETag. ETag is empty if object does not exist
+func (c *client) Read(ctx context.Context, config *c.Config, key string) ([]byte, string, error) {
+	object, err := c.GetObject(ctx, config.S3BackupBucket, key, minio.GetObjectOptions{})
+	if err != nil {
+		return nil, "", err
+	}
+	defer object.Close()
+	info, err := object.Stat()
+	if err != nil {
+		switch minio.ToErrorResponse(err).Code {
+		case minio.NoSuchKey:
+			return nil, "", nil
+		default:
+			return nil, "", err
+		}
+	}
+	b, err := io.ReadAll(object)
+	if err != nil {
+		return nil, "", err
+	}
+	return b, info.ETag, nil
+}
+
+// WriteIfMatch writes object only if existing ETag matches. Empty ETag writes only if object does not exist
+func (c *client) WriteIfMatch(ctx context.Context, config *c.Config, key string, data []byte, etag string) (bool, error) {
+	opts := minio.PutObjectOptions{
+		AutoChecksum: minio.ChecksumCRC32,
+	}
+	if etag == "" {
+		opts.SetMatchETagExcept("*")
+	} else {
+		opts.SetMatchETag(etag)
+	}
+	if _, err := c.PutObject(ctx, config.S3BackupBucket, key, bytes.NewReader(data), int64(len(data)), opts); err != nil {
+		if minio.ToErrorResponse(err).Code == minio.PreconditionFailed {
+			return false, nil
 		}
-		return size, fmt.Errorf("upload: failed to put object: %w", err)
+		return false, fmt.Errorf("write: failed to put object: %w", err)
 	}
-	return size, nil
+	return true, nil
 }
 
 func (c *client) cleanupIncomplete(config *c.Config, key string) error {
@@ -126,21 +219,38 @@ func (c *client) Remove(ctx context.Context, config *c.Config, keys []string) er
 }
 
 func (c *client) List(ctx context.Context, config *c.Config) []string {
+	var keys []string
+	for _, object := range c.ListInfo(ctx, config) {
+		keys = append(keys, object.Key)
+	}
+	return keys
+}
+
+// ListInfo returns backup objects in key order along with size and modified time
+// Objects under InternalKeyPrefix are skipped as backup key prefix may be empty or match it
+func (c *client) ListInfo(ctx context.Context, config *c.Config) []ObjectInfo {
 	objectCh := c.ListObjects(ctx, config.S3BackupBucket, minio.ListObjectsOptions{
 		Prefix:    config.S3BackupKeyPrefix,
 		Recursive: true,
 	})
-	var keys []string
+	var objects []ObjectInfo
 	for object := range objectCh {
 		if object.Err != nil {
 			config.Logger.Error("list object error", zap.Error(object.Err))
 			continue
 		}
+		if strings.HasPrefix(object.Key, InternalKeyPrefix) {
+			continue
+		}
 		if object.Size == 0 {
 			config.Logger.Error("list object size was 0")
 			continue
 		}
-		keys = append(keys, object.Key)
+		objects = append(objects, ObjectInfo{
+			Key:          object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+		})
 	}
-	return keys
+	return objects
 }
@@ -3,10 +3,13 @@ package s3client
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
 	"fmt"
+	"github.com/minio/minio-go/v7"
 	c "github.com/randomcoww/etcd-wrapper/pkg/config"
 	"github.com/randomcoww/etcd-wrapper/pkg/tlsutil"
 	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
 	"io"
 	"os"
 	"path/filepath"
@@ -41,15 +44,16 @@ func TestClient(t *testing.T) {
 
 	// --- upload --- //
 
-	size, err := minioClient.Upload(clientCtx, config, config.S3BackupKeyPrefix+"1.db", bytes.NewBufferString("test-data-1"))
+	size, sum, err := minioClient.Upload(clientCtx, config, config.S3BackupKeyPrefix+"1.db", bytes.NewBufferString("test-data-1"), nil)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(11), size)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256([]byte("test-data-1"))), sum)
 
-	size, err = minioClient.Upload(clientCtx, config, config.S3BackupKeyPrefix+"2.db", bytes.NewBufferString("test-data-22"))
+	size, _, err = minioClient.Upload(clientCtx, config, config.S3BackupKeyPrefix+"2.db", bytes.NewBufferString("test-data-22"), nil)
 	assert.NoError(t, err)
 	assert.Equal(t, int64(12), size)
 
-	size, err = minioClient.Upload(clientCtx, config, config.S3BackupKeyPrefix+"3.db", bytes.NewBufferString(""))
+	size, _, err = minioClient.Upload(clientCtx, config, config.S3BackupKeyPrefix+"3.db", bytes.NewBufferString(""), nil)
 	assert.Error(t, err)
 	assert.Equal(t, int64(0), size)
 
@@ -111,3 +115,154 @@ func TestClient(t *testing.T) {
 	assert.NoError(t, err)
 	assert.False(t, ok)
 }
+
+func TestClientListInfoInternal(t *testing.T) {
+	// empty prefix lists internal objects
+	config := &c.Config{
+		S3BackupHost:   "127.0.0.1:9000",
+		S3BackupBucket: "etcd",
+		Logger:         zap.NewNop(),
+	}
+	config.S3TLSConfig, _ = tlsutil.TLSCAConfig([]string{filepath.Join(baseTestPath, "minio", "certs", "CAs", "ca.crt")})
+	t.Setenv("AWS_ACCESS_KEY_ID", minioUser)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", minioPassword)
+
+	minioClient, err := NewClient(config)
+	assert.NoError(t, err)
+
+	clientCtx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	backupKey := fmt.Sprintf("internal-%d.db", time.Now().Unix())
+	internalKey := fmt.Sprintf("%sinternal-%d.json", InternalKeyPrefix, time.Now().Unix())
+	_, _, err = minioClient.Upload(clientCtx, config, backupKey, bytes.NewBufferString("test-data"), nil)
+	assert.NoError(t, err)
+	err = minioClient.Write(clientCtx, config, internalKey, []byte("test-data"))
+	assert.NoError(t, err)
+	defer minioClient.Remove(clientCtx, config, []string{backupKey, internalKey})
+
+	keys := minioClient.List(clientCtx, config)
+	assert.Contains(t, keys, backupKey)
+	assert.NotContains(t, keys, internalKey)
+}
+
+func TestClientWriteIfMatch(t *testing.T) {
+	config := &c.Config{
+		S3BackupHost:      "127.0.0.1:9000",
+		S3BackupBucket:    "etcd",
+		S3BackupKeyPrefix: fmt.Sprintf("client-%d-", time.Now().Unix()),
+	}
+	config.S3TLSConfig, _ = tlsutil.TLSCAConfig([]string{filepath.Join(baseTestPath, "minio", "certs", "CAs", "ca.crt")})
+	t.Setenv("AWS_ACCESS_KEY_ID", minioUser)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", minioPassword)
+
+	minioClient, err := NewClient(config)
+	assert.NoError(t, err)
+
+	clientCtx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+
+	key := config.S3BackupKeyPrefix + "lock.json"
+
+	// --- read missing --- //
+
+	b, etag, err := minioClient.Read(clientCtx, config, key)
+	assert.NoError(t, err)
+	assert.Equal(t, "", etag)
+	assert.Nil(t, b)
+
+	// --- write if not exists --- //
+
+	ok, err := minioClient.WriteIfMatch(clientCtx, config, key, []byte("data-1"), "")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = minioClient.WriteIfMatch(clientCtx, config, key, []byte("data-2"), "")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	b, etag, err = minioClient.Read(clientCtx, config, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data-1"), b)
+
+	// --- replace matching etag --- //
+
+	ok, err = minioClient.WriteIfMatch(clientCtx, config, key, []byte("data-3"), etag)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ok, err = minioClient.WriteIfMatch(clientCtx, config, key, []byte("data-4"), etag)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	b, _, err = minioClient.Read(clientCtx, config, key)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("data-3"), b)
+
+	// --- cleanup --- //
+
+	err = minioClient.Remove(clientCtx, config, []string{key})
+	assert.NoError(t, err)
+}
+
+type failingReader struct {
+	io.Reader
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if err == io.EOF {
+		return n, fmt.Errorf("snapshot stream failed")
+	}
+	return n, err
+}
+
+func TestClientUploadMultipart(t *testing.T) {
+	config := &c.Config{
+		S3BackupHost:      "127.0.0.1:9000",
+		S3BackupBucket:    "etcd",
+		S3BackupKeyPrefix: fmt.Sprintf("multipart-%d-", time.Now().Unix()),
+		UploadPartSize:    5 * 1024 * 1024,
+	}
+	config.S3TLSConfig, _ = tlsutil.TLSCAConfig([]string{filepath.Join(baseTestPath, "minio", "certs", "CAs", "ca.crt")})
+	t.Setenv("AWS_ACCESS_KEY_ID", minioUser)
+	t.Setenv("AWS_SECRET_ACCESS_KEY", minioPassword)
+
+	minioClient, err := NewClient(config)
+	assert.NoError(t, err)
+
+	clientCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// spans three parts
+	data := bytes.Repeat([]byte("0123456789abcdef"), 12*1024*1024/16)
+
+	size, _, err := minioClient.Upload(clientCtx, config, config.S3BackupKeyPrefix+"1.db", bytes.NewReader(data), map[string]string{
+		"Codec": "none",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(len(data)), size)
+
+	info, err := minioClient.StatObject(clientCtx, config.S3BackupBucket, config.S3BackupKeyPrefix+"1.db", minio.StatObjectOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%x", sha256.Sum256(data)), info.UserMetadata[SHA256MetadataKey])
+	assert.Equal(t, "none", info.UserMetadata["Codec"])
+
+	objects := minioClient.ListInfo(clientCtx, config)
+	assert.Equal(t, 1, len(objects))
+	assert.Equal(t, int64(len(data)), objects[0].Size)
+
+	// --- failed stream leaves no object or incomplete upload --- //
+
+	_, _, err = minioClient.Upload(clientCtx, config, config.S3BackupKeyPrefix+"2.db", &failingReader{bytes.NewReader(data)}, nil)
+	assert.Error(t, err)
+
+	assert.Equal(t, []string{config.S3BackupKeyPrefix + "1.db"}, minioClient.List(clientCtx, config))
+	for upload := range minioClient.ListIncompleteUploads(clientCtx, config.S3BackupBucket, config.S3BackupKeyPrefix, true) {
+		assert.NoError(t, upload.Err)
+		t.Errorf("incomplete upload %s not removed", upload.Key)
+	}
+
+	err = minioClient.Remove(clientCtx, config, []string{config.S3BackupKeyPrefix + "1.db"})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,129 @@
+package schedule
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	from := time.Date(2026, 3, 14, 10, 17, 30, 0, time.UTC) // Saturday
+
+	tests := []struct {
+		name    string
+		spec    string
+		want    []time.Time
+		wantErr bool
+	}{
+		{
+			name: "every",
+			spec: "@every 15m",
+			want: []time.Time{
+				time.Date(2026, 3, 14, 10, 30, 0, 0, time.UTC),
+				time.Date(2026, 3, 14, 10, 45, 0, 0, time.UTC),
+			},
+		},
+		{
+			// multiples since zero time do not restart at midnight
+			name: "every not dividing day",
+			spec: "@every 7h",
+			want: []time.Time{
+				time.Date(2026, 3, 14, 13, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 14, 20, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 15, 3, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "step",
+			spec: "TZ=UTC */20 * * * *",
+			want: []time.Time{
+				time.Date(2026, 3, 14, 10, 20, 0, 0, time.UTC),
+				time.Date(2026, 3, 14, 10, 40, 0, 0, time.UTC),
+				time.Date(2026, 3, 14, 11, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "business hours",
+			spec: "TZ=UTC 0 9-17/4 * * mon-fri",
+			want: []time.Time{
+				time.Date(2026, 3, 16, 9, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 16, 13, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 16, 17, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 17, 9, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "list and sunday as 7",
+			spec: "CRON_TZ=UTC 30 2,14 * * 7",
+			want: []time.Time{
+				time.Date(2026, 3, 15, 2, 30, 0, 0, time.UTC),
+				time.Date(2026, 3, 15, 14, 30, 0, 0, time.UTC),
+				time.Date(2026, 3, 22, 2, 30, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "day of month or day of week",
+			spec: "TZ=UTC 0 0 1 * mon",
+			want: []time.Time{
+				time.Date(2026, 3, 16, 0, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 23, 0, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 30, 0, 0, 0, 0, time.UTC),
+				time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "monthly",
+			spec: "TZ=UTC @monthly",
+			want: []time.Time{
+				time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2026, 5, 1, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "leap day",
+			spec: "TZ=UTC 0 0 29 feb *",
+			want: []time.Time{
+				time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+			},
+		},
+		{
+			name: "time zone",
+			spec: "TZ=America/New_York 0 2 * * *",
+			want: []time.Time{
+				time.Date(2026, 3, 15, 6, 0, 0, 0, time.UTC),
+				time.Date(2026, 3, 16, 6, 0, 0, 0, time.UTC),
+			},
+		},
+		{name: "too few fields", spec: "0 0 * *", wantErr: true},
+		{name: "out of range", spec: "60 * * * *", wantErr: true},
+		{name: "invalid range", spec: "0 5-1 * * *", wantErr: true},
+		{name: "invalid step", spec: "*/0 * * * *", wantErr: true},
+		{name: "invalid name", spec: "0 0 * * funday", wantErr: true},
+		{name: "unknown descriptor", spec: "@sometimes", wantErr: true},
+		{name: "short interval", spec: "@every 10ms", wantErr: true},
+		{name: "unknown time zone", spec: "TZ=Nowhere/City @daily", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+
+			next := from
+			for _, want := range tt.want {
+				next = s.Next(next)
+				assert.True(t, want.Equal(next), "want %v got %v", want, next)
+			}
+		})
+	}
+}
+
+func TestParseNoMatch(t *testing.T) {
+	s, err := Parse("0 0 31 feb *")
+	assert.NoError(t, err)
+	assert.True(t, s.Next(time.Now()).IsZero())
+}
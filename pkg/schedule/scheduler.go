@@ -0,0 +1,84 @@
+package schedule
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	MissedRunOnce = "run-once" // run once immediately for any number of missed scheduled times
+	MissedRunSkip = "skip"     // wait for the next scheduled time
+)
+
+// Scheduler calls a function at each scheduled time delayed by a random jitter
+// Runs never overlap. Scheduled times that pass while a run is in progress or while the process is not running are missed
+type Scheduler struct {
+	Name      string
+	Schedule  Schedule
+	Jitter    time.Duration
+	MissedRun string
+	Logger    *zap.Logger
+}
+
+// Run calls fn on schedule until ctx is cancelled
+// Last is the time of the last run before start if known and is used to find a run missed while the process was not running
+func (s *Scheduler) Run(ctx context.Context, last time.Time, fn func(context.Context)) {
+	now := time.Now()
+	next := s.Schedule.Next(now)
+	if !last.IsZero() {
+		next = s.missed(s.Schedule.Next(last), now)
+	}
+
+	for {
+		if next.IsZero() {
+			s.Logger.Error("no next scheduled time", zap.String("name", s.Name))
+			return
+		}
+		at := next
+		if s.Jitter > 0 && next.After(now) {
+			at = next.Add(rand.N(s.Jitter))
+		}
+		s.Logger.Info("next run scheduled", zap.String("name", s.Name), zap.Time("scheduled", next), zap.Time("at", at))
+
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		// catch up runs are due immediately and may race with cancel
+		if ctx.Err() != nil {
+			return
+		}
+		fn(ctx)
+
+		now = time.Now()
+		next = s.missed(s.Schedule.Next(next), now)
+	}
+}
+
+// missed returns the time to run next given the next scheduled time
+// If scheduled time has already passed, it returns now to catch up or the following scheduled time to skip
+func (s *Scheduler) missed(scheduled, now time.Time) time.Time {
+	if scheduled.IsZero() || scheduled.After(now) {
+		return scheduled
+	}
+	var count int
+	for t := scheduled; !t.IsZero() && !t.After(now) && count < 1000; t = s.Schedule.Next(t) {
+		count++
+	}
+	fields := []zap.Field{
+		zap.String("name", s.Name),
+		zap.Time("missed", scheduled),
+		zap.Int("count", count),
+	}
+	if s.MissedRun == MissedRunSkip {
+		s.Logger.Info("skipping missed run", fields...)
+		return s.Schedule.Next(now)
+	}
+	s.Logger.Info("running missed run", fields...)
+	return now
+}
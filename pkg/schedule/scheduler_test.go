@@ -0,0 +1,112 @@
+package schedule
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulerMissed(t *testing.T) {
+	now := time.Date(2026, 3, 14, 10, 17, 30, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		missedRun string
+		scheduled time.Time
+		want      time.Time
+	}{
+		{
+			name:      "not missed",
+			missedRun: MissedRunOnce,
+			scheduled: time.Date(2026, 3, 14, 10, 18, 0, 0, time.UTC),
+			want:      time.Date(2026, 3, 14, 10, 18, 0, 0, time.UTC),
+		},
+		{
+			name:      "run once",
+			missedRun: MissedRunOnce,
+			scheduled: time.Date(2026, 3, 14, 10, 5, 0, 0, time.UTC),
+			want:      now,
+		},
+		{
+			name:      "skip",
+			missedRun: MissedRunSkip,
+			scheduled: time.Date(2026, 3, 14, 10, 5, 0, 0, time.UTC),
+			want:      time.Date(2026, 3, 14, 10, 18, 0, 0, time.UTC),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Scheduler{
+				Schedule:  Every(time.Minute),
+				MissedRun: tt.missedRun,
+				Logger:    zap.NewNop(),
+			}
+			assert.Equal(t, tt.want, s.missed(tt.scheduled, now))
+		})
+	}
+}
+
+func TestSchedulerRun(t *testing.T) {
+	tests := []struct {
+		name      string
+		schedule  Schedule
+		missedRun string
+		last      time.Time
+		runTime   time.Duration
+		minRuns   int32
+		maxRuns   int32
+	}{
+		{
+			name:      "catch up run missed while stopped",
+			schedule:  Every(time.Hour),
+			missedRun: MissedRunOnce,
+			last:      time.Now().Add(-2 * time.Hour),
+			minRuns:   1,
+			maxRuns:   1,
+		},
+		{
+			name:      "skip run missed while stopped",
+			schedule:  Every(time.Hour),
+			missedRun: MissedRunSkip,
+			last:      time.Now().Add(-2 * time.Hour),
+			minRuns:   0,
+			maxRuns:   0,
+		},
+		{
+			name:      "runs longer than schedule do not overlap",
+			schedule:  Every(time.Second),
+			missedRun: MissedRunOnce,
+			runTime:   1500 * time.Millisecond,
+			minRuns:   2,
+			maxRuns:   4,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 4*time.Second)
+			defer cancel()
+
+			s := &Scheduler{
+				Name:      tt.name,
+				Schedule:  tt.schedule,
+				Jitter:    100 * time.Millisecond,
+				MissedRun: tt.missedRun,
+				Logger:    zap.NewNop(),
+			}
+			var runs, running atomic.Int32
+			s.Run(ctx, tt.last, func(ctx context.Context) {
+				assert.Equal(t, int32(1), running.Add(1))
+				runs.Add(1)
+				time.Sleep(tt.runTime)
+				running.Add(-1)
+			})
+			assert.GreaterOrEqual(t, runs.Load(), tt.minRuns)
+			assert.LessOrEqual(t, runs.Load(), tt.maxRuns)
+		})
+	}
+}